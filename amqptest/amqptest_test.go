@@ -0,0 +1,243 @@
+package amqptest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xcvc/amqp"
+)
+
+// serverOpen is an Open frame with container ID "srv".
+var serverOpen = []byte{
+	0x00, 0x00, 0x00, 0x13, 0x02, 0x00, 0x00, 0x00,
+	0x00, 0x53, 0x10, 0xc0, 0x06, 0x01, 0xa1, 0x03, 's', 'r', 'v',
+}
+
+// serverClose is a Close frame without an error.
+var serverClose = []byte{
+	0x00, 0x00, 0x00, 0x0c, 0x02, 0x00, 0x00, 0x00,
+	0x00, 0x53, 0x18, 0x45,
+}
+
+// serve responds to the client's protocol header with an AMQP
+// header and Open, replies to the client's Close, which follows
+// its Open, then discards everything the client sends.
+func serve(conn net.Conn) {
+	defer conn.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := conn.Write(header); err != nil {
+		return
+	}
+	if _, err := conn.Write(serverOpen); err != nil {
+		return
+	}
+
+	for frames := 0; frames < 2; {
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		size := int64(binary.BigEndian.Uint32(header)) - int64(len(header))
+		if _, err := io.CopyN(ioutil.Discard, conn, size); err != nil {
+			return
+		}
+		if size > 0 {
+			frames++ // not a keepalive
+		}
+	}
+	if _, err := conn.Write(serverClose); err != nil {
+		return
+	}
+	_, _ = io.Copy(ioutil.Discard, conn)
+}
+
+func record(t *testing.T) []byte {
+	t.Helper()
+
+	clientConn, serverConn := net.Pipe()
+	go serve(serverConn)
+
+	var recording bytes.Buffer
+	client, err := amqp.New(clientConn, Record(&recording), amqp.ConnContainerID("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return recording.Bytes()
+}
+
+func TestRecordReplay(t *testing.T) {
+	recording := record(t)
+
+	if !strings.Contains(string(recording), " W 414d515000010000\n") {
+		t.Errorf("protocol header not recorded:\n%s", recording)
+	}
+
+	conn, err := NewReplayConn(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := amqp.New(conn, amqp.ConnContainerID("client"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	recording := record(t)
+
+	conn, err := NewReplayConn(bytes.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := amqp.New(conn, amqp.ConnContainerID("other"))
+	if err == nil {
+		client.Close()
+		t.Fatal("New() succeeded with a different container ID")
+	}
+
+	var mismatch *MismatchError
+	if err := conn.Verify(); !errors.As(err, &mismatch) {
+		t.Fatalf("Verify() error = %v, want *MismatchError", err)
+	}
+	if mismatch.Offset != 8 {
+		t.Errorf("mismatch at offset %d, want 8, after the protocol header", mismatch.Offset)
+	}
+}
+
+func TestReplayConnOrdering(t *testing.T) {
+	recording := "2026-10-18T09:30:00Z W 0102\n" +
+		"2026-10-18T09:30:00Z W 0000000802000000\n" + // keepalive
+		"2026-10-18T09:30:01Z R 0304\n" +
+		"2026-10-18T09:30:02Z E \"EOF\"\n"
+
+	conn, err := NewReplayConn(strings.NewReader(recording))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	read := make(chan []byte)
+	go func() {
+		b := make([]byte, 8)
+		n, _ := conn.Read(b)
+		read <- b[:n]
+	}()
+
+	// the read must wait for the recorded write
+	select {
+	case b := <-read:
+		t.Fatalf("Read() returned %x before the recorded write", b)
+	default:
+	}
+
+	if _, err := conn.Write(keepalive); err != nil {
+		t.Fatalf("Write(keepalive) error = %v, want ignored", err)
+	}
+	if _, err := conn.Write([]byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-read; !bytes.Equal(b, []byte{3, 4}) {
+		t.Errorf("Read() = %x, want 0304", b)
+	}
+	if _, err := conn.Read(make([]byte, 8)); err != io.EOF {
+		t.Errorf("Read() error = %v, want %v", err, io.EOF)
+	}
+	if err := conn.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+// slowWriteConn is a net.Conn whose writes don't return until
+// unblock is closed.
+type slowWriteConn struct {
+	net.Conn
+	unblock chan struct{}
+}
+
+func (c *slowWriteConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	<-c.unblock
+	return n, err
+}
+
+func TestRecorderResponseOrder(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+	go func() {
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(serverConn, b); err != nil {
+			return
+		}
+		_, _ = serverConn.Write([]byte{2})
+	}()
+
+	var recording bytes.Buffer
+	conn := &slowWriteConn{Conn: clientConn, unblock: make(chan struct{})}
+	rec := NewRecorder(conn, &recording)
+
+	wrote := make(chan error)
+	go func() {
+		_, err := rec.Write([]byte{1})
+		wrote <- err
+	}()
+	// the response is read before the write returns
+	if _, err := io.ReadFull(rec, make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	close(conn.unblock)
+	if err := <-wrote; err != nil {
+		t.Fatal(err)
+	}
+
+	w := strings.Index(recording.String(), " W 01\n")
+	r := strings.Index(recording.String(), " R 02\n")
+	if w < 0 || r < w {
+		t.Errorf("response recorded before the request:\n%s", recording.String())
+	}
+}
+
+func TestReplayConnDeadlineAndClose(t *testing.T) {
+	conn, err := NewReplayConn(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Read(make([]byte, 8))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Errorf("Read() error = %v, want a net.Error timeout", err)
+	}
+
+	if err := conn.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Read(make([]byte, 8)); err != errClosed {
+		t.Errorf("Read() after Close error = %v, want %v", err, errClosed)
+	}
+	if _, err := conn.Write([]byte{1}); err != errClosed {
+		t.Errorf("Write() after Close error = %v, want %v", err, errClosed)
+	}
+	if err := conn.Close(); err != errClosed {
+		t.Errorf("second Close() error = %v, want %v", err, errClosed)
+	}
+}
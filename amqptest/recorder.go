@@ -0,0 +1,85 @@
+package amqptest
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/xcvc/amqp"
+)
+
+// Record returns an option for amqp.Dial and amqp.New recording the
+// bytes exchanged by the connection to w.
+//
+// With an amqps URL, the bytes are recorded before encryption. A
+// connection upgraded with amqp.ConnTLS is recorded encrypted, and
+// can't be replayed.
+func Record(w io.Writer) amqp.ConnOption {
+	return amqp.ConnWrapNet(func(conn net.Conn) net.Conn {
+		return NewRecorder(conn, w)
+	})
+}
+
+// Recorder is a net.Conn recording the bytes read from and written to
+// the wrapped connection.
+type Recorder struct {
+	net.Conn
+
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewRecorder returns a Recorder wrapping conn, writing the recording
+// to w.
+func NewRecorder(conn net.Conn, w io.Writer) *Recorder {
+	return &Recorder{Conn: conn, w: w}
+}
+
+// Read reads from the wrapped connection and records the bytes read,
+// or the error. Timeouts, used by the client to interrupt reads,
+// aren't recorded.
+func (r *Recorder) Read(b []byte) (int, error) {
+	n, err := r.Conn.Read(b)
+	if n > 0 {
+		r.record(entry{dir: dirRead, data: b[:n]})
+	}
+	if nerr, ok := err.(net.Error); err != nil && !(ok && nerr.Timeout()) {
+		r.record(entry{dir: dirError, err: err.Error()})
+	}
+	return n, err
+}
+
+// Write records the bytes and writes them to the wrapped connection.
+//
+// The bytes are recorded before they are written, so the peer's
+// response can't be recorded ahead of them. If the write fails,
+// the bytes are recorded nevertheless.
+func (r *Recorder) Write(b []byte) (int, error) {
+	if len(b) > 0 {
+		r.record(entry{dir: dirWrite, data: b})
+	}
+	return r.Conn.Write(b)
+}
+
+// Err returns the first error writing the recording, if any.
+//
+// Errors writing the recording don't affect the connection; once one
+// occurs, nothing more is recorded.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Recorder) record(e entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return
+	}
+	e.time = time.Now()
+	_, r.err = io.WriteString(r.w, e.String())
+}
@@ -0,0 +1,263 @@
+package amqptest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ReplayConn is a net.Conn replaying a recording made with Record.
+//
+// Reads return the recorded server bytes and errors in order. Each read
+// is delayed until the client has written the bytes recorded before it,
+// so the client sees the server's responses after its requests.
+//
+// Writes are compared with the bytes written when recording. A write
+// that doesn't match fails with a *MismatchError, which Verify reports.
+// Keepalive frames depend on timing and are ignored, both in the
+// recording and when written.
+//
+// Once the recording is exhausted, reads block until the ReplayConn is
+// closed or the read deadline expires.
+type ReplayConn struct {
+	mu      sync.Mutex
+	changed chan struct{} // closed and replaced when the state changes
+
+	writes   []byte // recorded bytes written by the client
+	writePos int    // bytes of writes matched by the client
+
+	reads []replayRead // recorded reads not yet returned
+
+	mismatch     *MismatchError
+	closed       bool
+	readDeadline time.Time
+}
+
+// replayRead is a recorded read.
+type replayRead struct {
+	after int    // recorded bytes written before the read
+	data  []byte // bytes read, if err is empty
+	err   string // error of a failed read
+}
+
+// MismatchError is returned when the client writes bytes that don't
+// match the recording.
+type MismatchError struct {
+	Offset int    // offset in the recorded bytes written by the client
+	Got    []byte // bytes written by the client
+	Want   []byte // recorded bytes at Offset, if any
+}
+
+func (e *MismatchError) Error() string {
+	if len(e.Want) == 0 {
+		return fmt.Sprintf("amqptest: unexpected write at offset %d after end of recording: %x", e.Offset, truncate(e.Got))
+	}
+	return fmt.Sprintf("amqptest: write at offset %d does not match recording:\n got  %x\n want %x",
+		e.Offset, truncate(e.Got), truncate(e.Want))
+}
+
+// truncate limits the bytes shown in errors.
+func truncate(b []byte) []byte {
+	const max = 256
+	if len(b) > max {
+		return b[:max]
+	}
+	return b
+}
+
+// NewReplayConn returns a ReplayConn replaying the recording read
+// from r.
+func NewReplayConn(r io.Reader) (*ReplayConn, error) {
+	entries, err := parseRecording(r)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ReplayConn{changed: make(chan struct{})}
+	for _, e := range entries {
+		switch e.dir {
+		case dirWrite:
+			if !isKeepalive(e.data) {
+				c.writes = append(c.writes, e.data...)
+			}
+		case dirRead:
+			c.reads = append(c.reads, replayRead{after: len(c.writes), data: e.data})
+		case dirError:
+			c.reads = append(c.reads, replayRead{after: len(c.writes), err: e.err})
+		}
+	}
+	return c, nil
+}
+
+// Read returns the next recorded bytes read, once the client has
+// written the bytes recorded before them.
+func (c *ReplayConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		switch {
+		case c.closed:
+			c.mu.Unlock()
+			return 0, errClosed
+		case c.mismatch != nil:
+			c.mu.Unlock()
+			return 0, c.mismatch
+		case len(c.reads) > 0 && c.writePos >= c.reads[0].after:
+			n, err := c.read(b)
+			c.mu.Unlock()
+			return n, err
+		}
+		var (
+			changed  = c.changed
+			deadline = c.readDeadline
+		)
+		c.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-changed
+			continue
+		}
+
+		d := time.Until(deadline)
+		if d <= 0 {
+			return 0, errTimeout{}
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// read returns the next recorded read, c.mu must be held.
+func (c *ReplayConn) read(b []byte) (int, error) {
+	next := &c.reads[0]
+	if next.err != "" {
+		c.reads = c.reads[1:]
+		if next.err == io.EOF.Error() {
+			return 0, io.EOF
+		}
+		return 0, errors.New(next.err)
+	}
+
+	n := copy(b, next.data)
+	next.data = next.data[n:]
+	if len(next.data) == 0 {
+		c.reads = c.reads[1:]
+	}
+	return n, nil
+}
+
+// Write compares b with the recorded bytes written by the client.
+func (c *ReplayConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch {
+	case c.closed:
+		return 0, errClosed
+	case c.mismatch != nil:
+		return 0, c.mismatch
+	case isKeepalive(b):
+		return len(b), nil
+	}
+
+	want := c.writes[c.writePos:]
+	if len(want) > len(b) {
+		want = want[:len(b)]
+	}
+	if string(want) != string(b) {
+		c.mismatch = &MismatchError{
+			Offset: c.writePos,
+			Got:    append([]byte(nil), b...),
+			Want:   append([]byte(nil), want...),
+		}
+		c.notify()
+		return 0, c.mismatch
+	}
+
+	c.writePos += len(b)
+	c.notify()
+	return len(b), nil
+}
+
+// Verify returns a *MismatchError if the client wrote bytes that
+// don't match the recording, or an error if the client didn't write
+// all the recorded bytes.
+func (c *ReplayConn) Verify() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.mismatch != nil {
+		return c.mismatch
+	}
+	if c.writePos < len(c.writes) {
+		return fmt.Errorf("amqptest: client wrote %d of %d recorded bytes, missing %x",
+			c.writePos, len(c.writes), truncate(c.writes[c.writePos:]))
+	}
+	return nil
+}
+
+// Close closes the connection, unblocking pending reads.
+func (c *ReplayConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errClosed
+	}
+	c.closed = true
+	c.notify()
+	return nil
+}
+
+// notify wakes up blocked reads, c.mu must be held.
+func (c *ReplayConn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// LocalAddr returns a placeholder address.
+func (c *ReplayConn) LocalAddr() net.Addr { return replayAddr{} }
+
+// RemoteAddr returns a placeholder address.
+func (c *ReplayConn) RemoteAddr() net.Addr { return replayAddr{} }
+
+// SetDeadline sets the read deadline. Writes never block.
+func (c *ReplayConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads.
+func (c *ReplayConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readDeadline = t
+	c.notify()
+	return nil
+}
+
+// SetWriteDeadline has no effect, writes never block.
+func (c *ReplayConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// errClosed is returned by operations on a closed ReplayConn.
+var errClosed = errors.New("amqptest: use of closed connection")
+
+// errTimeout is returned by reads once the read deadline expires.
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "amqptest: i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+type replayAddr struct{}
+
+func (replayAddr) Network() string { return "replay" }
+func (replayAddr) String() string  { return "replay" }
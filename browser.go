@@ -0,0 +1,123 @@
+package amqp
+
+import (
+	"context"
+)
+
+// defaultBrowseCredit is the maximum number of messages
+// requested from the server in a single drain.
+const defaultBrowseCredit = 100
+
+// Browser reads messages from a node without consuming them.
+//
+// Messages are requested in pages using drain semantics, allowing the
+// Browser to detect when all available messages have been read.
+//
+// A Browser is not safe for concurrent use.
+type Browser struct {
+	receiver *Receiver
+	pending  uint32 // credit of an incomplete drain, it must complete before the next
+}
+
+// NewBrowser opens a receiver on address with a distribution mode of
+// copy, allowing the messages to be read without removing them.
+//
+// The page size requested from the server can be configured with LinkCredit,
+// it must be greater than zero.
+//
+// Default page size: 100.
+func (s *Session) NewBrowser(address string, opts ...LinkOption) (*Browser, error) {
+	opts = append([]LinkOption{
+		LinkSourceAddress(address),
+		LinkDistributionMode(DistributionModeCopy),
+		LinkSenderSettle(ModeSettled),
+		LinkCredit(defaultBrowseCredit),
+		LinkBatching(false),
+	}, opts...)
+	opts = append(opts, func(l *link) error {
+		// a drain without credit returns no messages, Browse would never
+		// reach the end of the node
+		if l.receiver.maxCredit == 0 {
+			return errorNew("browser link credit must be greater than zero")
+		}
+		return nil
+	}, linkManualCredit())
+
+	r, err := s.NewReceiver(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Browser{receiver: r}, nil
+}
+
+// Browse returns up to limit messages, continuing from where the previous
+// call ended.
+//
+// Fewer than limit messages are returned only when the end of the node
+// has been reached. Messages not pre-settled by the server are accepted,
+// which has no effect on the node with a distribution mode of copy.
+//
+// If ctx expires while waiting for the server, ctx.Err() will be returned.
+// The pending messages will be returned by the next call to Browse.
+func (b *Browser) Browse(ctx context.Context, limit int) ([]*Message, error) {
+	var (
+		l    = b.receiver.link
+		msgs []*Message
+	)
+
+	for len(msgs) < limit {
+		credit := uint32(limit - len(msgs))
+		if credit > b.receiver.maxCredit {
+			credit = b.receiver.maxCredit
+		}
+
+		// request credit unless waiting for a previous request
+		if b.pending == 0 {
+			select {
+			case l.drain <- credit:
+				b.pending = credit
+			case <-l.done:
+				return msgs, l.err
+			case <-ctx.Done():
+				return msgs, ctx.Err()
+			}
+		}
+
+		// wait for the sender to use or discard all credit
+		select {
+		case <-l.drained:
+			credit = b.pending
+			b.pending = 0
+		case <-l.done:
+			return msgs, l.err
+		case <-ctx.Done():
+			return msgs, ctx.Err()
+		}
+
+		// all messages delivered before the drain completed are buffered
+		n := len(l.messages)
+		for i := 0; i < n; i++ {
+			msg := <-l.messages
+			msg.receiver = b.receiver
+			err := msg.Accept()
+			if err != nil {
+				return msgs, err
+			}
+			msg.settled = true
+			msgs = append(msgs, &msg)
+		}
+
+		// end of node
+		if uint32(n) < credit {
+			break
+		}
+	}
+
+	return msgs, nil
+}
+
+// Close closes the Browser and AMQP link.
+func (b *Browser) Close(ctx context.Context) error {
+	return b.receiver.Close(ctx)
+}
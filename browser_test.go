@@ -0,0 +1,118 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBrowse(t *testing.T) {
+	tests := []struct {
+		label     string
+		limit     int
+		transfers int  // messages sent by the peer for the drain
+		flow      bool // peer completes the drain with a flow
+		want      int
+	}{
+		{label: "full page", limit: 3, transfers: 3, want: 3},
+		{label: "full page and flow", limit: 3, transfers: 3, flow: true, want: 3},
+		{label: "partial page", limit: 3, transfers: 1, flow: true, want: 1},
+		{label: "empty queue", limit: 3, flow: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			c, _, stop := newTestConn(t)
+			defer stop()
+			b, l := newTestBrowser(t, newSession(c, 0))
+
+			go respondDrain(t, l, tt.transfers, tt.flow)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			msgs, err := b.Browse(ctx, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != tt.want {
+				t.Errorf("Browse() returned %d messages, want %d", len(msgs), tt.want)
+			}
+		})
+	}
+}
+
+func TestBrowseLateFlow(t *testing.T) {
+	c, _, stop := newTestConn(t)
+	defer stop()
+	b, l := newTestBrowser(t, newSession(c, 0))
+
+	go func() {
+		// the first page is completed by transfers, the peer's flow
+		// arrives after the next drain has been requested
+		respondDrain(t, l, 2, false)
+		credit, deliveryCount := uint32(0), uint32(2)
+		l.rx <- &performFlow{DeliveryCount: &deliveryCount, LinkCredit: &credit}
+		respondDrain(t, l, 1, true)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, want := range []int{2, 1} {
+		msgs, err := b.Browse(ctx, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != want {
+			t.Errorf("Browse() returned %d messages, want %d", len(msgs), want)
+		}
+	}
+}
+
+func TestNewBrowserZeroCredit(t *testing.T) {
+	c, frames, stop := newTestConn(t)
+	defer stop()
+
+	_, err := newSession(c, 0).NewBrowser("queue", LinkCredit(0))
+	if err == nil {
+		t.Fatal("NewBrowser() with zero credit succeeded")
+	}
+	select {
+	case fr := <-frames:
+		t.Errorf("NewBrowser() sent %#v", fr)
+	default:
+	}
+}
+
+func newTestBrowser(t *testing.T, s *Session) (*Browser, *link) {
+	t.Helper()
+	r := &Receiver{maxCredit: 10}
+	l := startTestLink(t, s, r)
+	return &Browser{receiver: r}, l
+}
+
+// respondDrain acts as the peer of l, sending transfers pre-settled
+// messages in response to a drain. If flow is true, the remaining
+// credit is then discarded with a flow.
+func respondDrain(t *testing.T, l *link, transfers int, flow bool) {
+	fr, ok := (<-l.session.tx).(*performFlow)
+	if !ok || !fr.Drain {
+		t.Errorf("expected drain flow, got %#v", fr)
+		return
+	}
+
+	for i := 0; i < transfers; i++ {
+		payload, err := NewMessage([]byte("browsed")).MarshalBinary()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		id := *fr.DeliveryCount + uint32(i)
+		l.rx <- &performTransfer{DeliveryID: &id, Settled: true, Payload: payload}
+	}
+
+	if flow {
+		credit := uint32(0)
+		deliveryCount := *fr.DeliveryCount + *fr.LinkCredit
+		l.rx <- &performFlow{DeliveryCount: &deliveryCount, LinkCredit: &credit}
+	}
+}
@@ -12,18 +12,24 @@ import (
 	"math/rand"
 	"net"
 	"net/url"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 )
 
 var (
-	// ErrSessionClosed is propagated to Sender/Receivers
-	// when Session.Close() is called.
+	// ErrSessionClosed is propagated to Sender/Receivers,
+	// wrapped in a *SessionError, when Session.Close() is called.
+	//
+	// As it is wrapped, check for it with errors.Is rather than ==.
 	ErrSessionClosed = errors.New("amqp: session closed")
 
-	// ErrLinkClosed returned by send and receive operations when
-	// Sender.Close() or Receiver.Close() are called.
+	// ErrLinkClosed is returned by send and receive operations,
+	// wrapped in a *LinkError, when Sender.Close() or Receiver.Close()
+	// are called.
+	//
+	// As it is wrapped, check for it with errors.Is rather than ==.
 	ErrLinkClosed = errors.New("amqp: link closed")
 )
 
@@ -40,6 +46,13 @@ type Client struct {
 // If username and password information is not empty it's used as SASL PLAIN
 // credentials, equal to passing ConnSASLPlain option.
 func Dial(addr string, opts ...ConnOption) (*Client, error) {
+	return DialContext(context.Background(), addr, opts...)
+}
+
+// DialContext is like Dial, but aborts connecting and establishing
+// the AMQP connection when ctx is done, in which case ctx.Err() is
+// returned.
+func DialContext(ctx context.Context, addr string, opts ...ConnOption) (*Client, error) {
 	u, err := url.Parse(addr)
 	if err != nil {
 		return nil, err
@@ -72,21 +85,52 @@ func Dial(addr string, opts ...ConnOption) (*Client, error) {
 	}
 	switch u.Scheme {
 	case "amqp", "":
-		c.net, err = net.Dial("tcp", host+":"+port)
+		dialer := new(net.Dialer)
+		c.net, err = dialer.DialContext(ctx, "tcp", host+":"+port)
 	case "amqps":
 		c.initTLSConfig()
 		c.tlsNegotiation = false
-		c.net, err = tls.Dial("tcp", host+":"+port, c.tlsConfig)
+		dialer := new(net.Dialer)
+		c.net, err = dialer.DialContext(ctx, "tcp", host+":"+port)
+		if err == nil {
+			c.net, err = tlsHandshake(ctx, c.net, c.tlsConfig)
+		}
 	default:
 		return nil, errorErrorf("unsupported scheme %q", u.Scheme)
 	}
 	if err != nil {
 		return nil, err
 	}
-	err = c.start()
+	err = c.startContext(ctx)
 	return &Client{conn: c}, err
 }
 
+// tlsHandshake performs a client TLS handshake over conn, abandoning it
+// when ctx is done. conn is closed if the handshake fails.
+func tlsHandshake(ctx context.Context, conn net.Conn, config *tls.Config) (net.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- tlsConn.Handshake()
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		// closing the connection unblocks the handshake
+		conn.Close()
+		<-errs
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
 // New establishes an AMQP client connection over conn.
 func New(conn net.Conn, opts ...ConnOption) (*Client, error) {
 	c, err := newConn(conn, opts...)
@@ -98,17 +142,41 @@ func New(conn net.Conn, opts ...ConnOption) (*Client, error) {
 }
 
 // Close disconnects the connection.
+//
+// Close waits for the peer to acknowledge the close for up to the
+// duration set by ConnConnectTimeout, or 5 seconds if it isn't set.
+// The connection is closed when the wait ends, without returning an error.
 func (c *Client) Close() error {
 	return c.conn.Close()
 }
 
+// CloseWithError disconnects the connection, sending e to the peer
+// as the reason for closing. A nil e closes without an error.
+//
+// CloseWithError waits for the peer to acknowledge the close until
+// ctx is done, in which case the connection is closed and ctx.Err()
+// is returned.
+func (c *Client) CloseWithError(ctx context.Context, e *Error) error {
+	return c.conn.closeWithError(ctx, e)
+}
+
 // NewSession opens a new AMQP session to the server.
 func (c *Client) NewSession(opts ...SessionOption) (*Session, error) {
+	return c.NewSessionContext(context.Background(), opts...)
+}
+
+// NewSessionContext is like NewSession, but stops waiting for the
+// server's response when ctx is done, in which case ctx.Err() is returned.
+//
+// If the server responds after ctx is done, the session is ended.
+func (c *Client) NewSessionContext(ctx context.Context, opts ...SessionOption) (*Session, error) {
 	// get a session allocated by Client.mux
 	var sResp newSessionResp
 	select {
 	case <-c.conn.done:
 		return nil, c.conn.getErr()
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	case sResp = <-c.conn.newSession:
 	}
 
@@ -120,7 +188,7 @@ func (c *Client) NewSession(opts ...SessionOption) (*Session, error) {
 	for _, opt := range opts {
 		err := opt(s)
 		if err != nil {
-			_ = s.Close(context.Background()) // deallocate session on error
+			s.deallocate()
 			return nil, err
 		}
 	}
@@ -131,8 +199,12 @@ func (c *Client) NewSession(opts ...SessionOption) (*Session, error) {
 		IncomingWindow: s.incomingWindow,
 		OutgoingWindow: s.outgoingWindow,
 		HandleMax:      s.handleMax,
+
+		OfferedCapabilities: s.offeredCapabilities,
+		DesiredCapabilities: s.desiredCapabilities,
+		Properties:          s.properties,
 	}
-	debug(1, "TX: %s", begin)
+	s.log(LogDebug, "TX", "frame", begin)
 	s.txFrame(begin, nil)
 
 	// wait for response
@@ -140,20 +212,52 @@ func (c *Client) NewSession(opts ...SessionOption) (*Session, error) {
 	select {
 	case <-c.conn.done:
 		return nil, c.conn.getErr()
+	case <-ctx.Done():
+		go s.abandon()
+		return nil, ctx.Err()
 	case fr = <-s.rx:
 	}
-	debug(1, "RX: %s", fr.body)
+
+	return s, s.begun(fr)
+}
+
+// begun starts the session after the server's response to Begin is received.
+func (s *Session) begun(fr frame) error {
+	s.log(LogDebug, "RX", "frame", fr.body)
 
 	begin, ok := fr.body.(*performBegin)
 	if !ok {
-		_ = s.Close(context.Background()) // deallocate session on error
-		return nil, errorErrorf("unexpected begin response: %+v", fr.body)
+		s.deallocate()
+		return errorErrorf("unexpected begin response: %+v", fr.body)
 	}
 
 	// start Session multiplexor
+	s.remoteBegin = begin
 	go s.mux(begin)
 
-	return s, nil
+	return nil
+}
+
+// abandon waits for the server's response to a Begin which is no
+// longer wanted, then ends the session.
+func (s *Session) abandon() {
+	var fr frame
+	select {
+	case <-s.conn.done:
+		return
+	case fr = <-s.rx:
+	}
+	if s.begun(fr) == nil {
+		_ = s.Close(context.Background())
+	}
+}
+
+// deallocate releases the channel of a session which was not begun.
+func (s *Session) deallocate() {
+	select {
+	case s.conn.delSession <- s:
+	case <-s.conn.done:
+	}
 }
 
 // Default session options
@@ -202,6 +306,51 @@ func SessionMaxLinks(n int) SessionOption {
 	}
 }
 
+// SessionProperty sets an entry in the session properties map sent to the server.
+//
+// This option can be used multiple times.
+func SessionProperty(key, value string) SessionOption {
+	return func(s *Session) error {
+		if key == "" {
+			return errorNew("session property key must not be empty")
+		}
+		if s.properties == nil {
+			s.properties = make(map[symbol]interface{})
+		}
+		s.properties[symbol(key)] = value
+		return nil
+	}
+}
+
+// SessionOfferedCapabilities adds capabilities offered to the server
+// when the session begins.
+//
+// This option can be used multiple times.
+func SessionOfferedCapabilities(capabilities ...string) SessionOption {
+	return func(s *Session) error {
+		for _, capability := range capabilities {
+			s.offeredCapabilities = append(s.offeredCapabilities, symbol(capability))
+		}
+		return nil
+	}
+}
+
+// SessionDesiredCapabilities adds capabilities requested of the server
+// when the session begins.
+//
+// Capabilities granted by the server can be checked with
+// Session.CapabilityGranted.
+//
+// This option can be used multiple times.
+func SessionDesiredCapabilities(capabilities ...string) SessionOption {
+	return func(s *Session) error {
+		for _, capability := range capabilities {
+			s.desiredCapabilities = append(s.desiredCapabilities, symbol(capability))
+		}
+		return nil
+	}
+}
+
 // Session is an AMQP session.
 //
 // A session multiplexes Receivers.
@@ -223,9 +372,18 @@ type Session struct {
 
 	nextDeliveryID uint32 // atomically accessed sequence for deliveryIDs
 
+	properties          map[symbol]interface{} // additional properties sent upon session begin
+	offeredCapabilities multiSymbol            // capabilities sent upon session begin
+	desiredCapabilities multiSymbol            // capabilities requested upon session begin
+	remoteBegin         *performBegin          // begin frame received from peer
+
+	sendInterceptors    []SendInterceptor    // default interceptors of Senders, after the conn's
+	receiveInterceptors []ReceiveInterceptor // default interceptors of Receivers, after the conn's
+
 	// used for gracefully closing link
 	close     chan struct{}
 	closeOnce sync.Once
+	closeErr  *Error // error sent to the peer in the End frame, set before close is closed
 	done      chan struct{}
 	err       error
 }
@@ -252,13 +410,25 @@ func newSession(c *conn, channel uint16) *Session {
 // If ctx expires while waiting for servers response, ctx.Err() will be returned.
 // The session will continue to wait for the response until the Client is closed.
 func (s *Session) Close(ctx context.Context) error {
-	s.closeOnce.Do(func() { close(s.close) })
+	return s.CloseWithError(ctx, nil)
+}
+
+// CloseWithError gracefully closes the session, sending e to the peer
+// as the reason for ending it. A nil e closes without an error.
+//
+// If ctx expires while waiting for servers response, ctx.Err() will be returned.
+// The session will continue to wait for the response until the Client is closed.
+func (s *Session) CloseWithError(ctx context.Context, e *Error) error {
+	s.closeOnce.Do(func() {
+		s.closeErr = e
+		close(s.close)
+	})
 	select {
 	case <-s.done:
 	case <-ctx.Done():
 		return ctx.Err()
 	}
-	if s.err == ErrSessionClosed {
+	if errors.Is(s.err, ErrSessionClosed) {
 		return nil
 	}
 	return s.err
@@ -287,19 +457,40 @@ func randString(n int) string {
 
 // NewReceiver opens a new receiver link on the session.
 func (s *Session) NewReceiver(opts ...LinkOption) (*Receiver, error) {
+	return s.NewReceiverContext(context.Background(), opts...)
+}
+
+// NewReceiverContext is like NewReceiver, but stops waiting for the
+// server's response when ctx is done, in which case ctx.Err() is returned.
+//
+// If the link was partially attached, it is detached in the background.
+func (s *Session) NewReceiverContext(ctx context.Context, opts ...LinkOption) (*Receiver, error) {
 	r := &Receiver{
 		batching:    DefaultLinkBatching,
 		batchMaxAge: DefaultLinkBatchMaxAge,
 		maxCredit:   DefaultLinkCredit,
 	}
 
-	l, err := attachLink(s, r, opts)
+	l, err := attachLink(ctx, s, r, opts)
+	if err != nil && r.retryPolicy != nil {
+		// r.retryPolicy is set by LinkRetryPolicy during the first attempt
+		err = r.retryPolicy.run(ctx, s.done, isAttachRetryable, func() error {
+			l, err = attachLink(ctx, s, r, opts)
+			return err
+		})
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	r.link = l
 
+	interceptors := s.receiveInterceptorChain(l.receiveInterceptors)
+	if len(interceptors) > 0 {
+		r.invoker = chainReceiveInterceptors(interceptors, r.receive)
+	}
+	r.propagator, r.traceCarrier = l.tracePropagator()
+
 	// batching is just extra overhead when maxCredits == 1
 	if r.maxCredit == 1 {
 		r.batching = false
@@ -315,9 +506,45 @@ func (s *Session) NewReceiver(opts ...LinkOption) (*Receiver, error) {
 	return r, nil
 }
 
+// NewDurableSubscriber opens a receiver on a durable subscription to topic.
+//
+// The subscription is identified by the link name and the connection's
+// container ID, both of which must remain the same across restarts for the
+// subscription to be found again. As such, the Client must be created
+// with ConnContainerID.
+//
+// The source is configured as durable with an expiry policy of never, so the
+// subscription continues collecting messages while the receiver is detached.
+// Close detaches the receiver without removing the subscription, use
+// Receiver.Unsubscribe to remove it.
+//
+// opts are applied after the subscription options and may be used to
+// configure credit, filters, etc.
+func (s *Session) NewDurableSubscriber(topic, subscriptionName string, opts ...LinkOption) (*Receiver, error) {
+	if !s.conn.containerIDSet {
+		return nil, errorNew("durable subscriptions require a container ID set with ConnContainerID")
+	}
+	if subscriptionName == "" {
+		return nil, errorNew("subscription name must not be empty")
+	}
+
+	opts = append([]LinkOption{
+		LinkName(subscriptionName),
+		LinkSourceAddress(topic),
+		LinkSourceDurability(DurabilityUnsettledState),
+		LinkSourceExpiryPolicy(ExpiryNever),
+		LinkSourceCapabilities("topic"),
+		linkDurable(),
+	}, opts...)
+
+	return s.NewReceiver(opts...)
+}
+
 // Sender sends messages on a single AMQP link.
 type Sender struct {
-	link *link
+	link      *link
+	anonymous bool        // link has a null target, messages are routed by Properties.To
+	invoker   SendInvoker // sendRetry with interceptors applied, sendRetry is called directly if nil
 
 	mu              sync.Mutex // protects buf and nextDeliveryTag
 	buf             buffer
@@ -333,7 +560,50 @@ type Sender struct {
 // has been requested (receiver settle mode is "Second"). In this case,
 // additional messages can be sent while the current goroutine is waiting
 // for the confirmation.
+//
+// If a RetryPolicy was configured with LinkRetryPolicy, Send is retried
+// on transient errors.
+//
+// Send interceptors, configured with ConnSendInterceptor,
+// SessionSendInterceptor and LinkSendInterceptor, are called once
+// per call to Send.
 func (s *Sender) Send(ctx context.Context, msg *Message) error {
+	if s.invoker != nil {
+		return s.invoker(ctx, msg)
+	}
+	return s.sendRetry(ctx, msg)
+}
+
+// newSender returns a Sender for an attached link, applying
+// interceptors to Send.
+func newSender(l *link, anonymous bool) *Sender {
+	s := &Sender{link: l, anonymous: anonymous}
+
+	interceptors := l.session.sendInterceptorChain(l.sendInterceptors)
+	if p, carrier := l.tracePropagator(); p != nil {
+		// inject last, so the trace context of interceptors is sent
+		interceptors = append(interceptors, injectInterceptor(p, carrier))
+	}
+	if len(interceptors) > 0 {
+		s.invoker = chainSendInterceptors(interceptors, s.sendRetry)
+	}
+	return s
+}
+
+// sendRetry sends msg, retrying according to the link's RetryPolicy.
+func (s *Sender) sendRetry(ctx context.Context, msg *Message) error {
+	if s.link.retryPolicy != nil {
+		return s.link.retryPolicy.run(ctx, s.link.done, IsTransient, func() error {
+			return s.sendOnce(ctx, msg)
+		})
+	}
+	return s.sendOnce(ctx, msg)
+}
+
+// sendOnce sends msg and waits for confirmation without retrying.
+func (s *Sender) sendOnce(ctx context.Context, msg *Message) error {
+	start := time.Now()
+
 	done, err := s.send(ctx, msg)
 	if err != nil {
 		return err
@@ -342,6 +612,7 @@ func (s *Sender) Send(ctx context.Context, msg *Message) error {
 	// wait for transfer to be confirmed
 	select {
 	case state := <-done:
+		s.link.session.conn.metrics.MessageSent(s.link.address(), time.Since(start), outcomeOf(state))
 		if state, ok := state.(*stateRejected); ok {
 			return state.Error
 		}
@@ -356,6 +627,10 @@ func (s *Sender) Send(ctx context.Context, msg *Message) error {
 // send is separated from Send so that the mutex unlock can be deferred without
 // locking the transfer confirmation that happens in Send.
 func (s *Sender) send(ctx context.Context, msg *Message) (chan deliveryState, error) {
+	if s.anonymous && (msg.Properties == nil || msg.Properties.To == "") {
+		return nil, errorNew("message sent on anonymous sender must have Properties.To set")
+	}
+
 	if len(msg.DeliveryTag) > maxDeliveryTagLength {
 		return nil, errorErrorf("delivery tag is over the allowed %v bytes, len: %v", maxDeliveryTagLength, len(msg.DeliveryTag))
 	}
@@ -439,19 +714,72 @@ func (s *Sender) Address() string {
 	return s.link.target.Address
 }
 
+// RemoteTarget returns the target terminus confirmed by the peer
+// when the link was attached.
+//
+// Returns nil if the peer did not send a target.
+func (s *Sender) RemoteTarget() *Terminus {
+	return s.link.remoteAttach.Target.terminus()
+}
+
 // Close closes the Sender and AMQP link.
 func (s *Sender) Close(ctx context.Context) error {
 	return s.link.Close(ctx)
 }
 
+// CloseWithError closes the Sender and AMQP link, sending e to the
+// peer as the reason for detaching. A nil e closes without an error.
+//
+// If ctx expires while waiting for servers response, ctx.Err() will be returned.
+// The session will continue to wait for the response until the Session or Client
+// is closed.
+func (s *Sender) CloseWithError(ctx context.Context, e *Error) error {
+	return s.link.closeWithErrorContext(ctx, e)
+}
+
 // NewSender opens a new sender link on the session.
 func (s *Session) NewSender(opts ...LinkOption) (*Sender, error) {
-	l, err := attachLink(s, nil, opts)
+	return s.NewSenderContext(context.Background(), opts...)
+}
+
+// NewSenderContext is like NewSender, but stops waiting for the
+// server's response when ctx is done, in which case ctx.Err() is returned.
+//
+// If the link was partially attached, it is detached in the background.
+func (s *Session) NewSenderContext(ctx context.Context, opts ...LinkOption) (*Sender, error) {
+	l, err := attachLink(ctx, s, nil, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSender(l, false), nil
+}
+
+// capabilityAnonymousRelay is offered by peers which route messages
+// sent on a link with a null target by their "to" property.
+const capabilityAnonymousRelay symbol = "ANONYMOUS-RELAY"
+
+// NewAnonymousSender opens a sender link with a null target address.
+//
+// The server routes each message to the address in its Properties.To,
+// which must be set on every message sent. This allows a single Sender to
+// send to any number of addresses.
+//
+// The server must offer the ANONYMOUS-RELAY capability when the connection
+// is opened.
+func (s *Session) NewAnonymousSender(opts ...LinkOption) (*Sender, error) {
+	if !s.conn.peerOpen.OfferedCapabilities.contains(capabilityAnonymousRelay) {
+		return nil, errorErrorf("server does not offer the %s capability", capabilityAnonymousRelay)
+	}
+
+	opts = append(opts, linkTargetAnonymous())
+
+	l, err := attachLink(context.Background(), s, nil, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Sender{link: l}, nil
+	return newSender(l, true), nil
 }
 
 func (s *Session) mux(remoteBegin *performBegin) {
@@ -476,11 +804,21 @@ func (s *Session) mux(remoteBegin *performBegin) {
 		remoteOutgoingWindow = remoteBegin.OutgoingWindow
 	)
 
+	// set while transfers are blocked by flow control,
+	// to report each exhaustion of the windows once
+	var windowExhausted bool
+
 	for {
 		txTransfer := s.txTransfer
 		// disable txTransfer if flow control windows have been exceeded
 		if remoteIncomingWindow == 0 || s.outgoingWindow == 0 {
 			txTransfer = nil
+			if !windowExhausted {
+				windowExhausted = true
+				s.conn.metrics.SessionWindowExhausted()
+			}
+		} else {
+			windowExhausted = false
 		}
 
 		select {
@@ -491,7 +829,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 
 		// session is being closed by user
 		case <-s.close:
-			s.txFrame(&performEnd{}, nil)
+			s.txFrame(&performEnd{Error: s.closeErr}, nil)
 
 			// discard frames until End is received or conn closed
 		EndLoop:
@@ -511,7 +849,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 			// release session
 			select {
 			case s.conn.delSession <- s:
-				s.err = ErrSessionClosed
+				s.err = &SessionError{inner: ErrSessionClosed}
 			case <-s.conn.done:
 				s.err = s.conn.getErr()
 			}
@@ -539,7 +877,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 
 		// incoming frame for link
 		case fr := <-s.rx:
-			debug(1, "RX(Session): %s", fr.body)
+			s.log(LogDebug, "RX", "frame", fr.body)
 
 			switch body := fr.body.(type) {
 			// Disposition frames can reference transfers from more than one
@@ -594,7 +932,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 							Description: "next-incoming-id not set after session established",
 						},
 					}, nil)
-					s.err = errors.New("protocol error: received flow without next-incoming-id after session established")
+					s.err = &SessionError{inner: errors.New("protocol error: received flow without next-incoming-id after session established")}
 					return
 				}
 
@@ -635,7 +973,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 						NextOutgoingID: nextOutgoingID,
 						OutgoingWindow: s.outgoingWindow,
 					}
-					debug(1, "TX: %s", resp)
+					s.log(LogDebug, "TX", "frame", resp)
 					s.txFrame(resp, nil)
 				}
 
@@ -687,7 +1025,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 						NextOutgoingID: nextOutgoingID,
 						OutgoingWindow: s.outgoingWindow,
 					}
-					debug(1, "TX(Session): %s", flow)
+					s.log(LogDebug, "TX", "frame", flow)
 					s.txFrame(flow, nil)
 				}
 
@@ -700,11 +1038,11 @@ func (s *Session) mux(remoteBegin *performBegin) {
 
 			case *performEnd:
 				s.txFrame(&performEnd{}, nil)
-				s.err = errorErrorf("session ended by server: %s", body.Error)
+				s.err = &SessionError{RemoteError: body.Error, Remote: true}
 				return
 
 			default:
-				fmt.Printf("Unexpected frame: %s\n", body)
+				s.log(LogWarn, "unexpected frame", "frame", body)
 			}
 
 		case fr := <-txTransfer:
@@ -737,7 +1075,7 @@ func (s *Session) mux(remoteBegin *performBegin) {
 				fr.done = nil
 			}
 
-			debug(2, "TX(Session): %s", fr)
+			s.log(LogTrace, "TX", "frame", fr)
 			s.txFrame(fr, fr.done)
 
 			// "Upon sending a transfer, the sending endpoint will increment
@@ -754,12 +1092,12 @@ func (s *Session) mux(remoteBegin *performBegin) {
 				fr.IncomingWindow = s.incomingWindow
 				fr.NextOutgoingID = nextOutgoingID
 				fr.OutgoingWindow = s.outgoingWindow
-				debug(1, "TX(Session): %s", fr)
+				s.log(LogDebug, "TX", "frame", fr)
 				s.txFrame(fr, nil)
 			case *performTransfer:
 				panic("transfer frames must use txTransfer")
 			default:
-				debug(1, "TX(Session): %s", fr)
+				s.log(LogDebug, "TX", "frame", fr)
 				s.txFrame(fr, nil)
 			}
 		}
@@ -785,6 +1123,14 @@ func (e *DetachError) Error() string {
 	return fmt.Sprintf("link detached, reason: %+v", e.RemoteError)
 }
 
+// Unwrap returns RemoteError.
+func (e *DetachError) Unwrap() error {
+	if e.RemoteError == nil {
+		return nil
+	}
+	return e.RemoteError
+}
+
 // Default link options
 const (
 	DefaultLinkCredit      = 1
@@ -792,6 +1138,10 @@ const (
 	DefaultLinkBatchMaxAge = 5 * time.Second
 )
 
+// releaseTimeout bounds draining on close when the link was not
+// closed by Close or CloseWithError, which provide a ctx.
+const releaseTimeout = 5 * time.Second
+
 // link is a unidirectional route.
 //
 // May be used for sending or receiving.
@@ -805,13 +1155,25 @@ type link struct {
 	closeOnce     sync.Once            // closeOnce protects close from being closed multiple times
 	close         chan struct{}        // close signals the mux to shutdown
 	done          chan struct{}        // done is closed by mux/muxDetach when the link is fully detached
-	detachErrorMu sync.Mutex           // protects detachError
+	detachErrorMu sync.Mutex           // protects detachError, detachWait, closeCtx and durable
 	detachError   *Error               // error to send to remote on detach, set by closeWithError
+	detachWait    bool                 // wait for the remote's detach after sending detachError
+	closeCtx      context.Context      // ctx passed to Close, bounds draining on close if set
+	durable       bool                 // send a non-closing detach on close, retaining the remote terminus
 	session       *Session             // parent session
 	receiver      *Receiver            // allows link options to modify Receiver
 	source        *source
 	target        *target
 	properties    map[symbol]interface{} // additional properties sent upon link attach
+	offeredCaps   multiSymbol            // capabilities sent upon link attach
+	desiredCaps   multiSymbol            // capabilities requested upon link attach
+	remoteAttach  *performAttach         // attach frame received from peer
+	retryPolicy   *RetryPolicy           // retry policy applied to sends
+
+	sendInterceptors    []SendInterceptor           // interceptors of Sender.Send, after the session's
+	receiveInterceptors []ReceiveInterceptor        // interceptors of Receiver.Receive, after the session's
+	propagator          Propagator                  // trace context propagator, overrides the conn's
+	traceCarrier        func(*Message) TraceCarrier // carrier of propagator, MessageCarrier if nil
 
 	// "The delivery-count is initialized by the sender when a link endpoint is created,
 	// and is incremented whenever a message is sent. Only the sender MAY independently
@@ -828,6 +1190,10 @@ type link struct {
 	err                error // err returned on Close()
 
 	// message receiving
+	manualCredit  bool          // credit is only issued by drain requests, see Browser
+	drain         chan uint32   // drain requests containing the credit to issue, nil unless manualCredit
+	drained       chan struct{} // signaled by mux when the sender has completed a drain
+	draining      bool          // drain flow sent, waiting for sender to complete it
 	paused        uint32        // atomically accessed; indicates that all link credits have been used by sender
 	receiverReady chan struct{} // receiver sends on this when mux is paused to indicate it can handle more messages
 	messages      chan Message  // used to send completed messages to receiver
@@ -837,7 +1203,7 @@ type link struct {
 }
 
 // attachLink is used by Receiver and Sender to create new links
-func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
+func attachLink(ctx context.Context, s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	l, err := newLink(s, r, opts)
 	if err != nil {
 		return nil, err
@@ -857,6 +1223,8 @@ func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	select {
 	case <-s.done:
 		return nil, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
 	case s.allocateHandle <- l:
 	}
 
@@ -864,6 +1232,9 @@ func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	select {
 	case <-s.done:
 		return nil, s.err
+	case <-ctx.Done():
+		go l.abandonHandle()
+		return nil, ctx.Err()
 	case <-l.rx:
 	}
 
@@ -881,6 +1252,9 @@ func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 		Source:             l.source,
 		Target:             l.target,
 		Properties:         l.properties,
+
+		OfferedCapabilities: l.offeredCaps,
+		DesiredCapabilities: l.desiredCaps,
 	}
 
 	if isReceiver {
@@ -898,7 +1272,7 @@ func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	}
 
 	// send Attach frame
-	debug(1, "TX: %s", attach)
+	l.log(LogDebug, "TX", "frame", attach)
 	s.txFrame(attach, nil)
 
 	// wait for response
@@ -906,14 +1280,26 @@ func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	select {
 	case <-s.done:
 		return nil, s.err
+	case <-ctx.Done():
+		go l.abandonAttach()
+		return nil, ctx.Err()
 	case fr = <-l.rx:
 	}
-	debug(3, "RX: %s", fr)
+	l.log(LogDebug, "RX", "frame", fr)
 	resp, ok := fr.(*performAttach)
 	if !ok {
 		return nil, errorErrorf("unexpected attach response: %#v", fr)
 	}
 
+	// "If the remote peer does not create a terminus, it MUST send
+	// the attach with a null source or target, then immediately
+	// detach the link with an error."
+	if (isReceiver && resp.Source == nil) || (!isReceiver && resp.Target == nil) {
+		return nil, l.attachRefused(ctx)
+	}
+
+	l.remoteAttach = resp
+
 	if l.maxMessageSize == 0 || resp.MaxMessageSize < l.maxMessageSize {
 		l.maxMessageSize = resp.MaxMessageSize
 	}
@@ -946,6 +1332,67 @@ func attachLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	return l, nil
 }
 
+// attachRefused waits for the detach following an attach response
+// with a null terminus, completes the detach and returns the peer's error.
+func (l *link) attachRefused(ctx context.Context) error {
+	var fr frameBody
+	select {
+	case <-l.session.done:
+		return l.session.err
+	case <-ctx.Done():
+		go l.muxDetach()
+		return ctx.Err()
+	case fr = <-l.rx:
+	}
+	l.log(LogDebug, "RX", "frame", fr)
+
+	detach, ok := fr.(*performDetach)
+	if !ok {
+		go l.muxDetach()
+		return errorErrorf("unexpected frame after refused attach: %#v", fr)
+	}
+
+	l.detachReceived = true
+	l.muxDetach()
+
+	return &LinkError{
+		RemoteError: detach.Error,
+		Remote:      true,
+		inner:       &DetachError{detach.Error},
+	}
+}
+
+// abandonHandle waits for the handle allocation of a link which is
+// no longer wanted, then deallocates it.
+func (l *link) abandonHandle() {
+	select {
+	case <-l.session.done:
+		return
+	case <-l.rx:
+	}
+
+	// allocation failed, nothing to release
+	if l.err != nil {
+		return
+	}
+
+	select {
+	case l.session.deallocateHandle <- l:
+	case <-l.session.done:
+	}
+}
+
+// abandonAttach waits for the server's response to an Attach which is
+// no longer wanted, then detaches the link and deallocates its handle.
+func (l *link) abandonAttach() {
+	select {
+	case <-l.session.done:
+		return
+	case <-l.rx:
+	}
+	l.muxDetach()
+}
+
 func newLink(s *Session, r *Receiver, opts []LinkOption) (*link, error) {
 	l := &link{
 		name:          randString(40),
@@ -973,10 +1420,22 @@ func (l *link) mux() {
 	var (
 		isReceiver = l.receiver != nil
 		isSender   = !isReceiver
+
+		// set while the link has no credit, to report each stall once.
+		// A link starts without credit, which isn't a stall.
+		stalled = true
 	)
 
 Loop:
 	for {
+		// credit used up by a drain, as by Browser, isn't a stall
+		if l.linkCredit == 0 && !stalled && !l.manualCredit {
+			stalled = true
+			l.session.conn.metrics.LinkCreditStalled(l.address(), isSender)
+		} else if l.linkCredit > 0 {
+			stalled = false
+		}
+
 		var outgoingTransfers chan performTransfer
 		switch {
 		// enable outgoing transfers case if sender and credits are available
@@ -984,7 +1443,7 @@ Loop:
 			outgoingTransfers = l.transfers
 
 		// if receiver && half maxCredits have been processed, send more credits
-		case isReceiver && l.linkCredit+uint32(len(l.messages)) <= l.receiver.maxCredit/2:
+		case isReceiver && !l.manualCredit && l.linkCredit+uint32(len(l.messages)) <= l.receiver.maxCredit/2:
 			l.err = l.muxFlow()
 			if l.err != nil {
 				return
@@ -1005,7 +1464,10 @@ Loop:
 
 		// send data
 		case tr := <-outgoingTransfers:
-			debug(3, "TX(link): %s", tr)
+			// check the level first, boxing tr would copy it
+			if l.session.conn.logger.Enabled(LogTrace) {
+				l.log(LogTrace, "TX", "frame", tr)
+			}
 
 			// Ensure the session mux is not blocked
 			for {
@@ -1023,7 +1485,7 @@ Loop:
 						return
 					}
 				case <-l.close:
-					l.err = ErrLinkClosed
+					l.err = &LinkError{inner: ErrLinkClosed}
 					return
 				case <-l.session.done:
 					l.err = l.session.err
@@ -1031,10 +1493,23 @@ Loop:
 				}
 			}
 
+		// drain requested by Browser
+		case credit := <-l.drain:
+			l.err = l.muxDrain(credit)
+			if l.err != nil {
+				return
+			}
+
 		case <-l.receiverReady:
 			continue
 		case <-l.close:
-			l.err = ErrLinkClosed
+			if isReceiver && l.receiver.closeState != nil {
+				l.err = l.muxReleaseBuffered()
+				if l.err != nil {
+					return
+				}
+			}
+			l.err = &LinkError{inner: ErrLinkClosed}
 			return
 		case <-l.session.done:
 			l.err = l.session.err
@@ -1056,7 +1531,7 @@ func (l *link) muxFlow() error {
 		DeliveryCount: &deliveryCount,
 		LinkCredit:    &linkCredit, // max number of messages
 	}
-	debug(3, "TX: %s", fr)
+	l.log(LogTrace, "TX", "frame", fr)
 
 	// Update credit. This must happen before entering loop below
 	// because incoming messages handled while waiting to transmit
@@ -1064,6 +1539,108 @@ func (l *link) muxFlow() error {
 	// out of sync with the server.
 	l.linkCredit = linkCredit
 
+	return l.muxTxFlow(fr)
+}
+
+// muxReleaseBuffered drains the link's credit, then settles every
+// message that was received but not read with receiver.closeState.
+//
+// The drain is abandoned when the ctx passed to Close is done, or
+// after releaseTimeout without one. Messages received after that are
+// not released.
+func (l *link) muxReleaseBuffered() error {
+	if l.linkCredit > 0 {
+		l.detachErrorMu.Lock()
+		ctx := l.closeCtx
+		l.detachErrorMu.Unlock()
+		if ctx == nil {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(context.Background(), releaseTimeout)
+			defer cancel()
+		}
+
+		// l.close is already closed, so muxTxFlow can't be used
+		var (
+			flow = l.drainFlow(l.linkCredit)
+			tx   = l.session.tx
+		)
+
+		// send the drain, then wait for the sender to use
+		// or discard the remaining credit
+	Drain:
+		for tx != nil || l.draining {
+			select {
+			case tx <- flow:
+				tx = nil
+			case fr := <-l.rx:
+				err := l.muxHandleFrame(fr)
+				if err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				l.draining = false
+				break Drain
+			case <-l.session.done:
+				return l.session.err
+			}
+		}
+	}
+
+	var ids []uint32
+Buffered:
+	for {
+		select {
+		case msg := <-l.messages:
+			if !msg.settled {
+				ids = append(ids, msg.deliveryID)
+			}
+		default:
+			break Buffered
+		}
+	}
+
+	for _, rng := range deliveryRanges(ids) {
+		var last *uint32
+		if rng[1] != rng[0] {
+			lastCopy := rng[1]
+			last = &lastCopy
+		}
+		err := l.receiver.sendDisposition(rng[0], last, l.receiver.closeState)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// muxDrain sends a flow granting credit with drain set. The sender
+// must use all of the credit or advance the delivery-count to consume
+// it, then respond with a flow indicating that no credit remains.
+func (l *link) muxDrain(credit uint32) error {
+	return l.muxTxFlow(l.drainFlow(credit))
+}
+
+// drainFlow returns a flow requesting that the sender use or discard
+// credit, and marks the link as draining.
+func (l *link) drainFlow(credit uint32) *performFlow {
+	deliveryCount := l.deliveryCount
+
+	fr := &performFlow{
+		Handle:        &l.handle,
+		DeliveryCount: &deliveryCount,
+		LinkCredit:    &credit,
+		Drain:         true,
+	}
+	l.log(LogTrace, "TX", "frame", fr)
+
+	l.linkCredit = credit
+	l.draining = true
+
+	return fr
+}
+
+// muxTxFlow sends fr to the session mux.
+func (l *link) muxTxFlow(fr *performFlow) error {
 	// Ensure the session mux is not blocked
 	for {
 		select {
@@ -1075,7 +1652,7 @@ func (l *link) muxFlow() error {
 				return err
 			}
 		case <-l.close:
-			return ErrLinkClosed
+			return &LinkError{inner: ErrLinkClosed}
 		case <-l.session.done:
 			return l.session.err
 		}
@@ -1138,9 +1715,24 @@ func (l *link) muxReceive(fr performTransfer) error {
 	l.deliveryCount++
 	l.linkCredit--
 
+	// a sender isn't required to send a flow when a drain
+	// is completed by using all of the credit
+	if l.draining && l.linkCredit == 0 {
+		l.muxDrained()
+	}
+
 	return nil
 }
 
+// muxDrained marks the drain requested by drainFlow as complete.
+func (l *link) muxDrained() {
+	l.draining = false
+	select {
+	case l.drained <- struct{}{}:
+	default:
+	}
+}
+
 // muxHandleFrame processes fr based on type.
 func (l *link) muxHandleFrame(fr frameBody) error {
 	var (
@@ -1151,7 +1743,7 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 	switch fr := fr.(type) {
 	// message frame
 	case *performTransfer:
-		debug(3, "RX: %s", fr)
+		l.log(LogTrace, "RX", "frame", fr)
 		if isSender {
 			// Senders should never receive transfer frames, but handle it just in case.
 			l.closeWithError(&Error{
@@ -1165,7 +1757,7 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 
 	// flow control frame
 	case *performFlow:
-		debug(3, "RX: %s", fr)
+		l.log(LogTrace, "RX", "frame", fr)
 		if isSender {
 			linkCredit := *fr.LinkCredit - l.deliveryCount
 			if fr.DeliveryCount != nil {
@@ -1177,6 +1769,21 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 			l.linkCredit = linkCredit
 		}
 
+		// Sender has used or discarded all credit following
+		// a drain request.
+		//
+		// A flow sent after the previous drain was completed by
+		// transfers doesn't account for the credit of the current
+		// drain, and is ignored.
+		if !isSender && l.draining && fr.LinkCredit != nil && *fr.LinkCredit == 0 &&
+			(fr.DeliveryCount == nil || *fr.DeliveryCount-l.deliveryCount == l.linkCredit) {
+			if fr.DeliveryCount != nil {
+				l.deliveryCount = *fr.DeliveryCount
+			}
+			l.linkCredit = 0
+			l.muxDrained()
+		}
+
 		if !fr.Echo {
 			return nil
 		}
@@ -1193,12 +1800,12 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 			DeliveryCount: &deliveryCount,
 			LinkCredit:    &linkCredit, // max number of messages
 		}
-		debug(1, "TX: %s", resp)
+		l.log(LogDebug, "TX", "frame", resp)
 		l.session.txFrame(resp, nil)
 
 	// remote side is closing links
 	case *performDetach:
-		debug(1, "RX: %s", fr)
+		l.log(LogDebug, "RX", "frame", fr)
 		// don't currently support link detach and reattach
 		if !fr.Closed {
 			return errorErrorf("non-closing detach not supported: %+v", fr)
@@ -1207,10 +1814,14 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 		// set detach received and close link
 		l.detachReceived = true
 
-		return errorWrapf(&DetachError{fr.Error}, "received detach frame")
+		return errorWrapf(&LinkError{
+			RemoteError: fr.Error,
+			Remote:      true,
+			inner:       &DetachError{fr.Error},
+		}, "received detach frame")
 
 	case *performDisposition:
-		debug(3, "RX: %s", fr)
+		l.log(LogTrace, "RX", "frame", fr)
 
 		// Unblock receivers waiting for message disposition
 		if l.receiver != nil {
@@ -1234,12 +1845,11 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 			Last:    fr.Last,
 			Settled: true,
 		}
-		debug(1, "TX: %s", resp)
+		l.log(LogDebug, "TX", "frame", resp)
 		l.session.txFrame(resp, nil)
 
 	default:
-		debug(1, "RX: %s", fr)
-		fmt.Printf("Unexpected frame: %s\n", fr)
+		l.log(LogWarn, "unexpected frame", "frame", fr)
 	}
 
 	return nil
@@ -1253,13 +1863,45 @@ func (l *link) muxHandleFrame(fr frameBody) error {
 // The session will continue to wait for the response until the Session or Client
 // is closed.
 func (l *link) Close(ctx context.Context) error {
-	l.closeOnce.Do(func() { close(l.close) })
-	select {
+	return l.closeWithErrorContext(ctx, nil)
+}
+
+// closeWithErrorContext closes the link, sending de to the peer, and
+// waits for the peer's detach until ctx expires.
+func (l *link) closeWithErrorContext(ctx context.Context, de *Error) error {
+	l.startClose(ctx, de, false)
+	return l.waitClosed(ctx)
+}
+
+// startClose signals mux to detach the link, sending de and waiting
+// for the peer's detach, and reports whether the link was closed by
+// this call. If unsubscribe is true, a durable link is detached with
+// the closed flag set.
+func (l *link) startClose(ctx context.Context, de *Error, unsubscribe bool) bool {
+	var started bool
+	l.closeOnce.Do(func() {
+		l.detachErrorMu.Lock()
+		l.detachError = de
+		l.detachWait = true
+		l.closeCtx = ctx
+		if unsubscribe {
+			l.durable = false
+		}
+		l.detachErrorMu.Unlock()
+		close(l.close)
+		started = true
+	})
+	return started
+}
+
+// waitClosed waits until the link is detached or ctx expires.
+func (l *link) waitClosed(ctx context.Context) error {
+	select {
 	case <-l.done:
 	case <-ctx.Done():
 		return ctx.Err()
 	}
-	if l.err == ErrLinkClosed {
+	if errors.Is(l.err, ErrLinkClosed) {
 		return nil
 	}
 	return l.err
@@ -1309,11 +1951,13 @@ func (l *link) muxDetach() {
 
 	l.detachErrorMu.Lock()
 	detachError := l.detachError
+	detachWait := l.detachWait
+	closed := !l.durable
 	l.detachErrorMu.Unlock()
 
 	fr := &performDetach{
 		Handle: l.handle,
-		Closed: true,
+		Closed: closed,
 		Error:  detachError,
 	}
 
@@ -1325,7 +1969,7 @@ Loop:
 			break Loop
 		case fr := <-l.rx:
 			// discard incoming frames to avoid blocking session.mux
-			if fr, ok := fr.(*performDetach); ok && fr.Closed {
+			if fr, ok := fr.(*performDetach); ok && (fr.Closed || !closed) {
 				l.detachReceived = true
 			}
 		case <-l.session.done:
@@ -1337,8 +1981,8 @@ Loop:
 	}
 
 	// don't wait for remote to detach when already
-	// received or closing due to error
-	if l.detachReceived || detachError != nil {
+	// received or closing due to a protocol error
+	if l.detachReceived || (detachError != nil && !detachWait) {
 		return
 	}
 
@@ -1346,8 +1990,11 @@ Loop:
 		select {
 		// read from link until detach with Close == true is received,
 		// other frames are discarded.
+		//
+		// A non-closing detach is sufficient when the link was
+		// detached without closing.
 		case fr := <-l.rx:
-			if fr, ok := fr.(*performDetach); ok && fr.Closed {
+			if fr, ok := fr.(*performDetach); ok && (fr.Closed || !closed) {
 				return
 			}
 
@@ -1366,6 +2013,40 @@ Loop:
 // A link may be a Sender or a Receiver.
 type LinkOption func(*link) error
 
+// linkTargetAnonymous ensures that the sender's target address
+// is null.
+func linkTargetAnonymous() LinkOption {
+	return func(l *link) error {
+		if l.target != nil && l.target.Address != "" {
+			return errorNew("anonymous sender must not have a target address")
+		}
+		if l.dynamicAddr {
+			return errorNew("anonymous sender must not request a dynamic address")
+		}
+		return nil
+	}
+}
+
+// linkManualCredit configures a receiver to only issue
+// credit when requested on link.drain.
+func linkManualCredit() LinkOption {
+	return func(l *link) error {
+		l.manualCredit = true
+		l.drain = make(chan uint32)
+		l.drained = make(chan struct{}, 1)
+		return nil
+	}
+}
+
+// linkDurable configures the link to be detached without
+// closing so the remote terminus is retained.
+func linkDurable() LinkOption {
+	return func(l *link) error {
+		l.durable = true
+		return nil
+	}
+}
+
 // LinkName sets the link name.
 func LinkName(name string) LinkOption {
 	return func(l *link) error {
@@ -1417,6 +2098,35 @@ func linkProperty(key string, value interface{}) LinkOption {
 	}
 }
 
+// LinkOfferedCapabilities adds capabilities offered to the server
+// when the link is attached.
+//
+// This option can be used multiple times.
+func LinkOfferedCapabilities(capabilities ...string) LinkOption {
+	return func(l *link) error {
+		for _, capability := range capabilities {
+			l.offeredCaps = append(l.offeredCaps, symbol(capability))
+		}
+		return nil
+	}
+}
+
+// LinkDesiredCapabilities adds capabilities requested of the server
+// when the link is attached.
+//
+// Capabilities granted by the server can be checked with
+// Sender.CapabilityGranted or Receiver.CapabilityGranted.
+//
+// This option can be used multiple times.
+func LinkDesiredCapabilities(capabilities ...string) LinkOption {
+	return func(l *link) error {
+		for _, capability := range capabilities {
+			l.desiredCaps = append(l.desiredCaps, symbol(capability))
+		}
+		return nil
+	}
+}
+
 // LinkSourceAddress sets the source address.
 func LinkSourceAddress(addr string) LinkOption {
 	return func(l *link) error {
@@ -1450,6 +2160,186 @@ func LinkTargetAddress(addr string) LinkOption {
 	}
 }
 
+// LinkSourceDurability sets the durability policy of the source.
+//
+// Default: DurabilityNone.
+func LinkSourceDurability(d Durability) LinkOption {
+	return func(l *link) error {
+		if d > DurabilityUnsettledState {
+			return errorErrorf("invalid Durability %d", d)
+		}
+		if l.source == nil {
+			l.source = new(source)
+		}
+		l.source.Durable = uint32(d)
+		return nil
+	}
+}
+
+// LinkSourceExpiryPolicy sets the expiry policy of the source.
+//
+// Default: ExpirySessionEnd.
+func LinkSourceExpiryPolicy(p ExpiryPolicy) LinkOption {
+	return func(l *link) error {
+		err := p.validate()
+		if err != nil {
+			return err
+		}
+		if l.source == nil {
+			l.source = new(source)
+		}
+		l.source.ExpiryPolicy = symbol(p)
+		return nil
+	}
+}
+
+// LinkSourceTimeout sets the duration that an expiring source
+// will be retained.
+//
+// Resolution is seconds.
+//
+// Default: 0.
+func LinkSourceTimeout(d time.Duration) LinkOption {
+	return func(l *link) error {
+		if d < 0 {
+			return errorNew("source timeout cannot be negative")
+		}
+		if l.source == nil {
+			l.source = new(source)
+		}
+		l.source.Timeout = uint32(d / time.Second)
+		return nil
+	}
+}
+
+// LinkSourceCapabilities sets the capabilities requested of the source.
+func LinkSourceCapabilities(capabilities ...string) LinkOption {
+	return func(l *link) error {
+		if l.source == nil {
+			l.source = new(source)
+		}
+		for _, c := range capabilities {
+			l.source.Capabilities = append(l.source.Capabilities, symbol(c))
+		}
+		return nil
+	}
+}
+
+// LinkDistributionMode sets the distribution mode of the source.
+//
+// DistributionModeCopy requests a non-destructive receiver,
+// DistributionModeMove a destructive one. If not set, the node's
+// default is used.
+func LinkDistributionMode(mode DistributionMode) LinkOption {
+	return func(l *link) error {
+		if mode != DistributionModeMove && mode != DistributionModeCopy {
+			return errorErrorf("invalid DistributionMode %q", mode)
+		}
+		if l.source == nil {
+			l.source = new(source)
+		}
+		l.source.DistributionMode = symbol(mode)
+		return nil
+	}
+}
+
+// LinkSourceOutcomes sets the outcomes supported by the receiver.
+func LinkSourceOutcomes(outcomes ...Outcome) LinkOption {
+	return func(l *link) error {
+		if l.source == nil {
+			l.source = new(source)
+		}
+		for _, o := range outcomes {
+			if _, err := o.state(); err != nil {
+				return err
+			}
+			l.source.Outcomes = append(l.source.Outcomes, symbol(o))
+		}
+		return nil
+	}
+}
+
+// LinkDefaultOutcome sets the outcome the source applies to unsettled
+// deliveries when the link is detached before they are settled.
+func LinkDefaultOutcome(o Outcome) LinkOption {
+	return func(l *link) error {
+		state, err := o.state()
+		if err != nil {
+			return err
+		}
+		if l.source == nil {
+			l.source = new(source)
+		}
+		l.source.DefaultOutcome = state
+		return nil
+	}
+}
+
+// LinkTargetDurability sets the durability policy of the target.
+//
+// Default: DurabilityNone.
+func LinkTargetDurability(d Durability) LinkOption {
+	return func(l *link) error {
+		if d > DurabilityUnsettledState {
+			return errorErrorf("invalid Durability %d", d)
+		}
+		if l.target == nil {
+			l.target = new(target)
+		}
+		l.target.Durable = uint32(d)
+		return nil
+	}
+}
+
+// LinkTargetExpiryPolicy sets the expiry policy of the target.
+//
+// Default: ExpirySessionEnd.
+func LinkTargetExpiryPolicy(p ExpiryPolicy) LinkOption {
+	return func(l *link) error {
+		err := p.validate()
+		if err != nil {
+			return err
+		}
+		if l.target == nil {
+			l.target = new(target)
+		}
+		l.target.ExpiryPolicy = symbol(p)
+		return nil
+	}
+}
+
+// LinkTargetTimeout sets the duration that an expiring target
+// will be retained.
+//
+// Resolution is seconds.
+//
+// Default: 0.
+func LinkTargetTimeout(d time.Duration) LinkOption {
+	return func(l *link) error {
+		if d < 0 {
+			return errorNew("target timeout cannot be negative")
+		}
+		if l.target == nil {
+			l.target = new(target)
+		}
+		l.target.Timeout = uint32(d / time.Second)
+		return nil
+	}
+}
+
+// LinkTargetCapabilities sets the capabilities requested of the target.
+func LinkTargetCapabilities(capabilities ...string) LinkOption {
+	return func(l *link) error {
+		if l.target == nil {
+			l.target = new(target)
+		}
+		for _, c := range capabilities {
+			l.target.Capabilities = append(l.target.Capabilities, symbol(c))
+		}
+		return nil
+	}
+}
+
 // LinkAddressDynamic requests a dynamically created address from the server.
 func LinkAddressDynamic() LinkOption {
 	return func(l *link) error {
@@ -1526,19 +2416,22 @@ func LinkReceiverSettle(mode ReceiverSettleMode) LinkOption {
 
 // LinkFilter sets a filter on the link source.
 func LinkFilter(name string, code uint64, value string) LinkOption {
-	return func(l *link) error {
-		if l.source == nil {
-			l.source = new(source)
-		}
-		if l.source.Filter == nil {
-			l.source.Filter = make(map[symbol]*describedType)
-		}
-		l.source.Filter[symbol(name)] = &describedType{
-			descriptor: code,
-			value:      value,
-		}
-		return nil
-	}
+	return linkSourceFilter(name, code, value)
+}
+
+// LinkFilterValue sets a filter on the link source with a numeric
+// descriptor and an arbitrary AMQP value.
+//
+// value may be any type supported by the encoder, including maps
+// and lists.
+func LinkFilterValue(name string, code uint64, value interface{}) LinkOption {
+	return linkSourceFilter(name, code, value)
+}
+
+// LinkFilterSymbol sets a filter on the link source with a symbolic
+// descriptor and an arbitrary AMQP value.
+func LinkFilterSymbol(name, descriptor string, value interface{}) LinkOption {
+	return linkSourceFilter(name, symbol(descriptor), value)
 }
 
 // LinkSessionFilter sets a session filter (com.microsoft:session-filter) on the link source.
@@ -1554,8 +2447,60 @@ func LinkSelectorFilter(filter string) LinkOption {
 	return linkSourceFilter("apache.org:selector-filter:string", uint64(0x0000468C00000004), filter)
 }
 
+// LinkNoLocalFilter sets a no-local filter (apache.org:no-local-filter:list) on the link source.
+//
+// Messages published on the same connection will not be delivered
+// to the receiver.
+func LinkNoLocalFilter() LinkOption {
+	// <descriptor name="apache.org:no-local-filter:list" code="0x0000468C:0x00000003"/>
+	return linkSourceFilter("apache.org:no-local-filter:list", uint64(0x0000468C00000003), list{})
+}
+
+// LinkPropertiesFilter sets a property filter (amqp:properties-filter) on the link source.
+//
+// Keys are the names of the fields in the message properties section,
+// e.g. "subject" or "correlation-id", as defined in AMQP Filter Expressions.
+func LinkPropertiesFilter(props map[string]interface{}) LinkOption {
+	// <descriptor name="amqp:properties-filter" code="0x00000000:0x00000173"/>
+	m := make(map[symbol]interface{}, len(props))
+	for k, v := range props {
+		m[symbol(k)] = v
+	}
+	return linkSourceFilter("amqp:properties-filter", uint64(0x0000000000000173), m)
+}
+
+// LinkApplicationPropertiesFilter sets an application property filter
+// (amqp:application-properties-filter) on the link source.
+func LinkApplicationPropertiesFilter(props map[string]interface{}) LinkOption {
+	// <descriptor name="amqp:application-properties-filter" code="0x00000000:0x00000174"/>
+	return linkSourceFilter("amqp:application-properties-filter", uint64(0x0000000000000174), props)
+}
+
+// LinkSQLFilter sets a SQL filter (amqp:sql-filter) on the link source.
+func LinkSQLFilter(expr string) LinkOption {
+	// <descriptor name="amqp:sql-filter" code="0x00000000:0x00000120"/>
+	return linkSourceFilter("amqp:sql-filter", uint64(0x0000000000000120), expr)
+}
+
+// LinkOffsetFilter sets a stream offset filter (rabbitmq:stream-offset-spec)
+// on the link source.
+//
+// The receiver will start consuming from the given offset.
+func LinkOffsetFilter(offset int64) LinkOption {
+	return linkSourceFilter("rabbitmq:stream-offset-spec", symbol("rabbitmq:stream-offset-spec"), offset)
+}
+
+// LinkTimestampFilter sets a stream timestamp filter (rabbitmq:stream-offset-spec)
+// on the link source.
+//
+// The receiver will start consuming from the first message
+// stored at or after t.
+func LinkTimestampFilter(t time.Time) LinkOption {
+	return linkSourceFilter("rabbitmq:stream-offset-spec", symbol("rabbitmq:stream-offset-spec"), t)
+}
+
 // linkSourceFilter sets a filter on the link source.
-func linkSourceFilter(name string, code uint64, value string) LinkOption {
+func linkSourceFilter(name string, descriptor, value interface{}) LinkOption {
 	nameSym := symbol(name)
 	return func(l *link) error {
 		if l.source == nil {
@@ -1565,13 +2510,76 @@ func linkSourceFilter(name string, code uint64, value string) LinkOption {
 			l.source.Filter = make(map[symbol]*describedType)
 		}
 		l.source.Filter[nameSym] = &describedType{
-			descriptor: code,
+			descriptor: descriptor,
 			value:      value,
 		}
 		return nil
 	}
 }
 
+// LinkRetryPolicy sets the policy for retrying failed operations.
+//
+// For a Sender, Send is retried on transient errors. For a Receiver,
+// Session.NewReceiver retries attaching the link on retryable errors
+// while the session remains open.
+//
+// Send is retried on the same link, so retries stop once the link is
+// closed, whatever ShouldRetry returns; recovering from link, session
+// or connection errors requires a new Sender. A message whose outcome
+// is unknown may have been delivered, so a ShouldRetry accepting such
+// errors, e.g. ErrTimeout, may deliver duplicates.
+func LinkRetryPolicy(p RetryPolicy) LinkOption {
+	return func(l *link) error {
+		err := p.validate()
+		if err != nil {
+			return err
+		}
+		if l.receiver != nil {
+			l.receiver.retryPolicy = &p
+			return nil
+		}
+		l.retryPolicy = &p
+		return nil
+	}
+}
+
+// isAttachRetryable reports whether attaching a link may be retried on
+// the same session after failing with err.
+func isAttachRetryable(err error) bool {
+	var (
+		connErr    *ConnectionError
+		sessionErr *SessionError
+	)
+	if errors.As(err, &connErr) || errors.As(err, &sessionErr) {
+		return false
+	}
+	return IsRetryable(err)
+}
+
+// LinkReleaseOnClose enables a graceful close of the Receiver.
+//
+// When the Receiver is closed, the remaining link credit is drained
+// and every message received but not yet returned by Receive is
+// released before the link is detached, allowing the server to
+// redeliver them immediately. Draining stops when the ctx passed to
+// Close is done.
+//
+// If modify is true, the messages are settled as modified with
+// delivery-failed false instead of released.
+func LinkReleaseOnClose(modify bool) LinkOption {
+	return func(l *link) error {
+		if l.receiver == nil {
+			return errorNew("LinkReleaseOnClose is not valid for Sender")
+		}
+
+		l.receiver.closeState = &stateReleased{}
+		if modify {
+			l.receiver.closeState = &stateModified{DeliveryFailed: false}
+		}
+		return nil
+	}
+}
+
 // LinkMaxMessageSize sets the maximum message size that can
 // be sent or received on the link.
 //
@@ -1587,18 +2595,35 @@ func LinkMaxMessageSize(size uint64) LinkOption {
 
 // Receiver receives messages on a single AMQP link.
 type Receiver struct {
-	link         *link                   // underlying link
-	batching     bool                    // enable batching of message dispositions
-	batchMaxAge  time.Duration           // maximum time between the start n batch and sending the batch to the server
-	dispositions chan messageDisposition // message dispositions are sent on this channel when batching is enabled
-	maxCredit    uint32                  // maximum allowed inflight messages
-	inFlight     inFlight                // used to track message disposition when rcv-settle-mode == second
+	link         *link                       // underlying link
+	batching     bool                        // enable batching of message dispositions
+	batchMaxAge  time.Duration               // maximum time between the start n batch and sending the batch to the server
+	dispositions chan messageDisposition     // message dispositions are sent on this channel when batching is enabled
+	maxCredit    uint32                      // maximum allowed inflight messages
+	inFlight     inFlight                    // used to track message disposition when rcv-settle-mode == second
+	retryPolicy  *RetryPolicy                // retry policy applied to attach
+	closeState   deliveryState               // if set, buffered messages are settled with closeState on close
+	invoker      ReceiveInvoker              // receive with interceptors applied, receive is called directly if nil
+	propagator   Propagator                  // extracts trace context of received messages
+	traceCarrier func(*Message) TraceCarrier // carrier of propagator
 }
 
 // Receive returns the next message from the sender.
 //
 // Blocks until a message is received, ctx completes, or an error occurs.
+//
+// Receive interceptors, configured with ConnReceiveInterceptor,
+// SessionReceiveInterceptor and LinkReceiveInterceptor, are called
+// once per call to Receive.
 func (r *Receiver) Receive(ctx context.Context) (*Message, error) {
+	if r.invoker != nil {
+		return r.invoker(ctx)
+	}
+	return r.receive(ctx)
+}
+
+// receive returns the next message without applying interceptors.
+func (r *Receiver) receive(ctx context.Context) (*Message, error) {
 	if atomic.LoadUint32(&r.link.paused) == 1 {
 		select {
 		case r.link.receiverReady <- struct{}{}:
@@ -1637,6 +2662,17 @@ func (r *Receiver) Address() string {
 	return r.link.source.Address
 }
 
+// RemoteSource returns the source terminus confirmed by the peer
+// when the link was attached.
+//
+// Filters not present in the returned Terminus were not applied
+// by the peer.
+//
+// Returns nil if the peer did not send a source.
+func (r *Receiver) RemoteSource() *Terminus {
+	return r.link.remoteAttach.Source.terminus()
+}
+
 // Close closes the Receiver and AMQP link.
 //
 // If ctx expires while waiting for servers response, ctx.Err() will be returned.
@@ -1646,6 +2682,32 @@ func (r *Receiver) Close(ctx context.Context) error {
 	return r.link.Close(ctx)
 }
 
+// CloseWithError closes the Receiver and AMQP link, sending e to the
+// peer as the reason for detaching. A nil e closes without an error.
+//
+// If ctx expires while waiting for servers response, ctx.Err() will be returned.
+// The session will continue to wait for the response until the Session or Client
+// is closed.
+func (r *Receiver) CloseWithError(ctx context.Context, e *Error) error {
+	return r.link.closeWithErrorContext(ctx, e)
+}
+
+// Unsubscribe closes the Receiver and removes its durable subscription.
+//
+// Unlike Close, the link is detached with the closed flag set, indicating
+// to the server that the subscription should be deleted. To remove a
+// subscription that is not currently attached, open it with
+// Session.NewDurableSubscriber and then call Unsubscribe.
+//
+// If the Receiver is already closed, the subscription is retained and
+// an error wrapping ErrLinkClosed is returned.
+func (r *Receiver) Unsubscribe(ctx context.Context) error {
+	if !r.link.startClose(ctx, nil, true) {
+		return &LinkError{inner: ErrLinkClosed}
+	}
+	return r.link.waitClosed(ctx)
+}
+
 type messageDisposition struct {
 	id    uint32
 	state interface{}
@@ -1745,21 +2807,136 @@ func (r *Receiver) sendDisposition(first uint32, last *uint32, state interface{}
 		State:   state,
 	}
 
-	debug(1, "TX: %s", fr)
-	return r.link.session.txFrame(fr, nil)
+	r.link.log(LogDebug, "TX", "frame", fr)
+	err := r.link.session.txFrame(fr, nil)
+	if err != nil {
+		return err
+	}
+
+	count := 1
+	if last != nil {
+		count += int(*last - first)
+	}
+	r.link.session.conn.metrics.DispositionSent(r.link.address(), outcomeOf(state), count)
+	return nil
+}
+
+// Settle settles msgs with the same outcome.
+//
+// Delivery IDs are grouped into the fewest contiguous ranges, sending
+// one disposition frame per range regardless of the order of msgs.
+// Messages that were received settled are skipped.
+//
+// When rcv-settle-mode == second, Settle waits for the server to settle
+// every message. If ctx expires while waiting, ctx.Err() is returned.
+func (r *Receiver) Settle(ctx context.Context, msgs []*Message, d Disposition) error {
+	state, err := d.state()
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uint32, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.receiver != r {
+			return errorNew("message was not received by this Receiver")
+		}
+		if msg.shouldSendDisposition() {
+			ids = append(ids, msg.deliveryID)
+		}
+	}
+
+	modeSecond := r.link.receiverSettleMode != nil && *r.link.receiverSettleMode == ModeSecond
+
+	var waits []chan error
+	for _, rng := range deliveryRanges(ids) {
+		var last *uint32
+		if rng[1] != rng[0] {
+			lastCopy := rng[1]
+			last = &lastCopy
+		}
+
+		if modeSecond {
+			for id := rng[0]; ; id++ {
+				waits = append(waits, r.inFlight.add(id))
+				if id == rng[1] {
+					break
+				}
+			}
+		}
+
+		err := r.sendDisposition(rng[0], last, state)
+		if err != nil {
+			r.inFlight.remove(rng[0], last, nil)
+			return err
+		}
+	}
+
+	for _, wait := range waits {
+		select {
+		case err := <-wait:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// deliveryRanges sorts ids and groups them into contiguous
+// [first, last] ranges. Duplicate ids are ignored.
+func deliveryRanges(ids []uint32) [][2]uint32 {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	sorted := make([]uint32, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	ranges := [][2]uint32{{sorted[0], sorted[0]}}
+	for _, id := range sorted[1:] {
+		cur := &ranges[len(ranges)-1]
+		switch id {
+		case cur[1]:
+			// duplicate
+		case cur[1] + 1:
+			cur[1] = id
+		default:
+			ranges = append(ranges, [2]uint32{id, id})
+		}
+	}
+	return ranges
 }
 
-func (r *Receiver) messageDisposition(id uint32, state interface{}) error {
+// messageDisposition sends the disposition of a message and, when
+// rcv-settle-mode == second, waits for the server to settle it.
+//
+// If ctx expires while waiting for the server, ctx.Err() is returned and
+// the in-flight entry is kept until the server settles the message or
+// the link is detached.
+func (r *Receiver) messageDisposition(ctx context.Context, id uint32, state interface{}) error {
 	var wait chan error
 	if r.link.receiverSettleMode != nil && *r.link.receiverSettleMode == ModeSecond {
 		wait = r.inFlight.add(id)
 	}
 
 	if r.batching {
-		r.dispositions <- messageDisposition{id: id, state: state}
+		select {
+		case r.dispositions <- messageDisposition{id: id, state: state}:
+		case <-r.link.done:
+			r.inFlight.remove(id, nil, nil)
+			return r.link.err
+		case <-ctx.Done():
+			// the disposition was never queued, nothing to track
+			r.inFlight.remove(id, nil, nil)
+			return ctx.Err()
+		}
 	} else {
 		err := r.sendDisposition(id, nil, state)
 		if err != nil {
+			r.inFlight.remove(id, nil, nil)
 			return err
 		}
 	}
@@ -1768,7 +2945,12 @@ func (r *Receiver) messageDisposition(id uint32, state interface{}) error {
 		return nil
 	}
 
-	return <-wait
+	select {
+	case err := <-wait:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // inFlight tracks in-flight message dispositions allowing receivers
@@ -1,8 +1,13 @@
 package amqp
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
+	"net"
 	"testing"
+	"time"
 )
 
 func TestLinkOptions(t *testing.T) {
@@ -45,6 +50,65 @@ func TestLinkOptions(t *testing.T) {
 				"x-opt-test4": int64(1),
 			},
 		},
+		{
+			label: "typed-filters",
+			opts: []LinkOption{
+				LinkNoLocalFilter(),
+				LinkSQLFilter("color = 'red'"),
+				LinkPropertiesFilter(map[string]interface{}{"subject": "order"}),
+				LinkFilterSymbol("x-custom", "com.example:custom-filter", int32(5)),
+				LinkOffsetFilter(100),
+			},
+
+			wantSource: &source{
+				Filter: map[symbol]*describedType{
+					"apache.org:no-local-filter:list": {
+						descriptor: uint64(0x0000468C00000003),
+						value:      list{},
+					},
+					"amqp:sql-filter": {
+						descriptor: uint64(0x120),
+						value:      "color = 'red'",
+					},
+					"amqp:properties-filter": {
+						descriptor: uint64(0x173),
+						value:      map[symbol]interface{}{"subject": "order"},
+					},
+					"x-custom": {
+						descriptor: symbol("com.example:custom-filter"),
+						value:      int32(5),
+					},
+					"rabbitmq:stream-offset-spec": {
+						descriptor: symbol("rabbitmq:stream-offset-spec"),
+						value:      int64(100),
+					},
+				},
+			},
+		},
+		{
+			label: "terminus-options",
+			opts: []LinkOption{
+				LinkSourceAddress("topic"),
+				LinkSourceDurability(DurabilityUnsettledState),
+				LinkSourceExpiryPolicy(ExpiryNever),
+				LinkSourceTimeout(90 * time.Second),
+				LinkDistributionMode(DistributionModeCopy),
+				LinkSourceOutcomes(OutcomeAccepted, OutcomeReleased),
+				LinkDefaultOutcome(OutcomeReleased),
+				LinkSourceCapabilities("topic"),
+			},
+
+			wantSource: &source{
+				Address:          "topic",
+				Durable:          2,
+				ExpiryPolicy:     "never",
+				Timeout:          90,
+				DistributionMode: "copy",
+				DefaultOutcome:   &stateReleased{},
+				Outcomes:         []symbol{"amqp:accepted:list", "amqp:released:list"},
+				Capabilities:     []symbol{"topic"},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -63,4 +127,553 @@ func TestLinkOptions(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestTLSHandshakeContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	// the server never responds, so the handshake can only end by the deadline
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	conn, err := tlsHandshake(ctx, client, &tls.Config{ServerName: "localhost"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("tlsHandshake() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if conn != nil {
+		t.Errorf("tlsHandshake() returned a connection on error")
+	}
+	if _, err := client.Write([]byte{0}); err == nil {
+		t.Errorf("connection not closed after failed handshake")
+	}
+}
+
+func TestNewDurableSubscriberRequiresContainerID(t *testing.T) {
+	c, err := newConn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSession(c, 0)
+
+	_, err = s.NewDurableSubscriber("topic", "sub")
+	if err == nil {
+		t.Fatal("expected error when container ID not set")
+	}
+}
+
+func TestDurableReceiverDetach(t *testing.T) {
+	tests := []struct {
+		label      string
+		close      func(*Receiver, context.Context) error
+		wantClosed bool
+	}{
+		{label: "close", close: (*Receiver).Close, wantClosed: false},
+		{label: "unsubscribe", close: (*Receiver).Unsubscribe, wantClosed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			c, _, stop := newTestConn(t)
+			defer stop()
+
+			l := startTestLink(t, newSession(c, 0), &Receiver{}, linkDurable())
+			detached := respondDetach(l, &performDetach{Closed: tt.wantClosed})
+
+			// a non-closing detach reply must be accepted for durable links
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := tt.close(l.receiver, ctx); err != nil {
+				t.Fatal(err)
+			}
+			fr := <-detached
+			if fr.Closed != tt.wantClosed {
+				t.Errorf("detach Closed = %t, want %t", fr.Closed, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestLinkCloseWithError(t *testing.T) {
+	closers := []struct {
+		label    string
+		receiver bool
+		close    func(*link, context.Context, *Error) error
+	}{
+		{
+			label: "sender",
+			close: func(l *link, ctx context.Context, e *Error) error {
+				return (&Sender{link: l}).CloseWithError(ctx, e)
+			},
+		},
+		{
+			label:    "receiver",
+			receiver: true,
+			close: func(l *link, ctx context.Context, e *Error) error {
+				return l.receiver.CloseWithError(ctx, e)
+			},
+		},
+	}
+
+	for _, closer := range closers {
+		t.Run(closer.label, func(t *testing.T) {
+			tests := []struct {
+				label   string
+				reply   *performDetach
+				wantErr error
+			}{
+				{label: "detach reply", reply: &performDetach{Closed: true}},
+				// without a reply, CloseWithError waits until ctx expires
+				{label: "no reply", wantErr: context.DeadlineExceeded},
+			}
+
+			for _, tt := range tests {
+				t.Run(tt.label, func(t *testing.T) {
+					c, _, stop := newTestConn(t)
+					defer stop()
+
+					var r *Receiver
+					if closer.receiver {
+						r = &Receiver{}
+					}
+					l := startTestLink(t, newSession(c, 0), r)
+					detached := respondDetach(l, tt.reply)
+
+					ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+					defer cancel()
+
+					e := &Error{Condition: ErrorDetachForced, Description: "going away"}
+					if err := closer.close(l, ctx, e); err != tt.wantErr {
+						t.Errorf("CloseWithError() error = %v, want %v", err, tt.wantErr)
+					}
+
+					want := &performDetach{Handle: l.handle, Closed: true, Error: e}
+					if got := <-detached; !testEqual(got, want) {
+						t.Errorf("detach does not match expected:\n %s", testDiff(got, want))
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestUnsubscribeAfterClose(t *testing.T) {
+	c, _, stop := newTestConn(t)
+	defer stop()
+
+	l := startTestLink(t, newSession(c, 0), &Receiver{}, linkDurable())
+	detached := respondDetach(l, &performDetach{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.receiver.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if fr := <-detached; fr.Closed {
+		t.Error("Close sent a closing detach")
+	}
+
+	// the subscription was retained by Close, Unsubscribe can't remove it
+	if err := l.receiver.Unsubscribe(ctx); !errors.Is(err, ErrLinkClosed) {
+		t.Errorf("Unsubscribe() error = %v, want %v", err, ErrLinkClosed)
+	}
+}
+
+func TestAnonymousSender(t *testing.T) {
+	c, err := newConn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.peerOpen = &performOpen{}
+	s := newSession(c, 0)
+
+	_, err = s.NewAnonymousSender()
+	if err == nil {
+		t.Error("expected error when ANONYMOUS-RELAY not offered")
+	}
+
+	sender := &Sender{anonymous: true}
+	_, err = sender.send(context.Background(), NewMessage([]byte("hello")))
+	if err == nil {
+		t.Error("expected error when Properties.To not set")
+	}
+}
+
+func TestCapabilityOptions(t *testing.T) {
+	c, err := newConn(nil,
+		ConnOfferedCapabilities("ANONYMOUS-RELAY"),
+		ConnDesiredCapabilities("DELAYED_DELIVERY", "SHARED-SUBS"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (multiSymbol{"ANONYMOUS-RELAY"}); !testEqual(c.offeredCapabilities, want) {
+		t.Errorf("Conn offered capabilities don't match expected:\n %s", testDiff(c.offeredCapabilities, want))
+	}
+	if want := (multiSymbol{"DELAYED_DELIVERY", "SHARED-SUBS"}); !testEqual(c.desiredCapabilities, want) {
+		t.Errorf("Conn desired capabilities don't match expected:\n %s", testDiff(c.desiredCapabilities, want))
+	}
+
+	l, err := newLink(nil, nil, []LinkOption{
+		LinkOfferedCapabilities("foo"),
+		LinkDesiredCapabilities("bar"),
+		LinkDesiredCapabilities("baz"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (multiSymbol{"foo"}); !testEqual(l.offeredCaps, want) {
+		t.Errorf("Link offered capabilities don't match expected:\n %s", testDiff(l.offeredCaps, want))
+	}
+	if want := (multiSymbol{"bar", "baz"}); !testEqual(l.desiredCaps, want) {
+		t.Errorf("Link desired capabilities don't match expected:\n %s", testDiff(l.desiredCaps, want))
+	}
+
+	c.peerOpen = &performOpen{OfferedCapabilities: multiSymbol{"DELAYED_DELIVERY"}}
+	client := &Client{conn: c}
+	if !client.CapabilityGranted("DELAYED_DELIVERY") {
+		t.Error("expected DELAYED_DELIVERY to be granted")
+	}
+	if client.CapabilityGranted("SHARED-SUBS") {
+		t.Error("expected SHARED-SUBS not to be granted")
+	}
+}
+
+func TestNewSenderContextCanceled(t *testing.T) {
+	c, _, stop := newTestConn(t)
+	defer stop()
+	s := newSession(c, 0)
+
+	// allocate the handle, but never respond to the attach
+	allocated := make(chan *link)
+	go func() {
+		l := <-s.allocateHandle
+		l.rx <- nil
+		allocated <- l
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := s.NewSenderContext(ctx, LinkTargetAddress("target"))
+	if err != context.DeadlineExceeded {
+		t.Fatalf("NewSenderContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	l := <-allocated
+
+	// a late attach must be detached and the handle deallocated
+	l.rx <- &performAttach{Name: l.name, Role: roleReceiver}
+	select {
+	case fr := <-s.tx:
+		if detach, ok := fr.(*performDetach); !ok || !detach.Closed {
+			t.Fatalf("expected closing detach, got %#v", fr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("link was not detached")
+	}
+	l.rx <- &performDetach{Handle: l.handle, Closed: true}
+	select {
+	case got := <-s.deallocateHandle:
+		if got != l {
+			t.Error("deallocated the wrong link")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("handle was not deallocated")
+	}
+}
+
+func TestAttachRefused(t *testing.T) {
+	c, _, stop := newTestConn(t)
+	defer stop()
+	s := newSession(c, 0)
+
+	refusal := &Error{Condition: ErrorNotFound, Description: "no such queue"}
+	deallocated := make(chan *link, 1)
+	go func() {
+		l := <-s.allocateHandle
+		l.rx <- nil
+		l.rx <- &performAttach{Name: l.name, Role: roleReceiver}
+		l.rx <- &performDetach{Handle: l.handle, Closed: true, Error: refusal}
+		if fr, ok := (<-s.tx).(*performDetach); !ok || !fr.Closed {
+			t.Errorf("expected closing detach, got %#v", fr)
+		}
+		deallocated <- <-s.deallocateHandle
+	}()
+
+	_, err := s.NewSender(LinkTargetAddress("missing"))
+	var amqpErr *Error
+	if !errors.As(err, &amqpErr) || amqpErr != refusal {
+		t.Fatalf("NewSender() error = %v, want %v", err, refusal)
+	}
+	var linkErr *LinkError
+	if !errors.As(err, &linkErr) || !linkErr.Remote {
+		t.Errorf("NewSender() error = %#v, want remote *LinkError", err)
+	}
+
+	select {
+	case <-deallocated:
+	case <-time.After(time.Second):
+		t.Fatal("handle was not deallocated")
+	}
+}
+
+func TestSettlementContext(t *testing.T) {
+	c, frames, stop := newTestConn(t)
+	defer stop()
+
+	modeSecond := ModeSecond
+	r := &Receiver{link: &link{
+		session:            newSession(c, 0),
+		receiverSettleMode: &modeSecond,
+		done:               make(chan struct{}),
+	}}
+	msg := &Message{receiver: r, deliveryID: 3}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := msg.AcceptContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("AcceptContext() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, ok := r.inFlight.m[3]; !ok {
+		t.Error("in-flight entry was lost after ctx expired")
+	}
+	if fr := <-frames; !isDisposition(fr, &stateAccepted{}) {
+		t.Errorf("expected accepted disposition, got %#v", fr.body)
+	}
+}
+
+// Release used to skip the disposition of unsettled messages, and
+// send one for settled messages.
+func TestMessageRelease(t *testing.T) {
+	tests := []struct {
+		label       string
+		settled     bool
+		wantRelease bool
+	}{
+		{label: "unsettled", wantRelease: true},
+		{label: "settled", settled: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			c, frames, stop := newTestConn(t)
+			defer stop()
+
+			r := &Receiver{link: &link{session: newSession(c, 0), done: make(chan struct{})}}
+			msg := &Message{receiver: r, deliveryID: 4, settled: tt.settled}
+			if err := msg.Release(); err != nil {
+				t.Fatal(err)
+			}
+
+			select {
+			case fr := <-frames:
+				if !tt.wantRelease {
+					t.Errorf("Release sent %#v for a settled message", fr.body)
+				} else if !isDisposition(fr, &stateReleased{}) {
+					t.Errorf("expected released disposition, got %#v", fr.body)
+				}
+			case <-time.After(50 * time.Millisecond):
+				if tt.wantRelease {
+					t.Error("Release did not send a disposition")
+				}
+			}
+		})
+	}
+}
+
+func isDisposition(fr frame, state interface{}) bool {
+	d, ok := fr.body.(*performDisposition)
+	return ok && testEqual(d.State, state)
+}
+
+func TestDeliveryRanges(t *testing.T) {
+	tests := []struct {
+		ids  []uint32
+		want [][2]uint32
+	}{
+		{ids: nil, want: nil},
+		{ids: []uint32{5}, want: [][2]uint32{{5, 5}}},
+		{ids: []uint32{3, 1, 2, 2, 7, 9, 8, 11}, want: [][2]uint32{{1, 3}, {7, 9}, {11, 11}}},
+	}
+
+	for _, tt := range tests {
+		got := deliveryRanges(tt.ids)
+		if !testEqual(got, tt.want) {
+			t.Errorf("deliveryRanges(%v) does not match expected:\n %s", tt.ids, testDiff(got, tt.want))
+		}
+	}
+}
+
+func TestReceiverSettle(t *testing.T) {
+	c, frames, stop := newTestConn(t)
+	defer stop()
+
+	r := &Receiver{link: &link{session: newSession(c, 0)}}
+	var msgs []*Message
+	for _, id := range []uint32{4, 2, 3, 8} {
+		msgs = append(msgs, &Message{receiver: r, deliveryID: id})
+	}
+
+	rejectErr := &Error{Condition: ErrorDecodeError}
+	err := r.Settle(context.Background(), msgs, Disposition{Outcome: OutcomeRejected, Error: rejectErr})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := uint32(4)
+	want := []*performDisposition{
+		{Role: roleReceiver, First: 2, Last: &last, Settled: true, State: &stateRejected{Error: rejectErr}},
+		{Role: roleReceiver, First: 8, Settled: true, State: &stateRejected{Error: rejectErr}},
+	}
+	for _, w := range want {
+		select {
+		case fr := <-frames:
+			if !testEqual(fr.body, w) {
+				t.Errorf("disposition does not match expected:\n %s", testDiff(fr.body, w))
+			}
+		case <-time.After(time.Second):
+			t.Fatal("disposition not sent")
+		}
+	}
+}
+
+func TestReleaseOnClose(t *testing.T) {
+	tests := []struct {
+		label   string
+		respond func(t *testing.T, l *link)
+	}{
+		{
+			label: "flow",
+			respond: func(t *testing.T, l *link) {
+				// discard the remaining credit
+				respondDrain(t, l, 0, true)
+			},
+		},
+		{
+			label: "transfers",
+			respond: func(t *testing.T, l *link) {
+				// use the remaining credit, without a flow
+				respondDrain(t, l, 2, false)
+			},
+		},
+		{
+			label: "no response",
+			respond: func(t *testing.T, l *link) {
+				respondDrain(t, l, 0, false)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			c, frames, stop := newTestConn(t)
+			defer stop()
+
+			l, err := newLink(newSession(c, 0), &Receiver{}, []LinkOption{LinkReleaseOnClose(false)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			l.receiver.link = l
+			l.rx = make(chan frameBody, 1)
+			l.linkCredit = 2
+			l.messages = make(chan Message, 5)
+			l.messages <- Message{deliveryID: 5}
+			l.messages <- Message{deliveryID: 6}
+			l.messages <- Message{deliveryID: 7, settled: true}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			l.closeCtx = ctx
+
+			go tt.respond(t, l)
+
+			if err := l.muxReleaseBuffered(); err != nil {
+				t.Fatal(err)
+			}
+			if l.draining {
+				t.Error("link still draining")
+			}
+
+			last := uint32(6)
+			want := &performDisposition{Role: roleReceiver, First: 5, Last: &last, Settled: true, State: &stateReleased{}}
+			select {
+			case fr := <-frames:
+				if !testEqual(fr.body, want) {
+					t.Errorf("disposition does not match expected:\n %s", testDiff(fr.body, want))
+				}
+			case <-time.After(time.Second):
+				t.Fatal("disposition not sent")
+			}
+		})
+	}
+}
+
+func TestSessionCloseWithError(t *testing.T) {
+	tests := []struct {
+		label   string
+		reply   bool
+		wantErr error
+	}{
+		{label: "end reply", reply: true},
+		// without a reply, CloseWithError waits until ctx expires
+		{label: "no reply", wantErr: context.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			peer, netConn := newTestPeer(t, func(body frameBody) []frameBody {
+				switch body.(type) {
+				case *performOpen:
+					return []frameBody{&performOpen{ContainerID: "peer"}}
+				case *performBegin:
+					return []frameBody{&performBegin{RemoteChannel: 0, IncomingWindow: 100, OutgoingWindow: 100}}
+				case *performEnd:
+					if tt.reply {
+						return []frameBody{&performEnd{}}
+					}
+				case *performClose:
+					return []frameBody{&performClose{}}
+				}
+				return nil
+			})
+
+			client, err := New(netConn, ConnIdleTimeout(0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			session, err := client.NewSession()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			e := &Error{Condition: ErrorInternalError, Description: "going away"}
+			if err := session.CloseWithError(ctx, e); err != tt.wantErr {
+				t.Errorf("CloseWithError() error = %v, want %v", err, tt.wantErr)
+			}
+
+			want := &performEnd{Error: e}
+			for body := range peer.received {
+				if end, ok := body.(*performEnd); ok {
+					if !testEqual(end, want) {
+						t.Errorf("end does not match expected:\n %s", testDiff(end, want))
+					}
+					return
+				}
+			}
+			t.Error("end not received")
+		})
+	}
+}
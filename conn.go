@@ -2,8 +2,10 @@ package amqp
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
 	"math"
 	"net"
@@ -22,8 +24,10 @@ const (
 var (
 	ErrTimeout = errors.New("amqp: timeout waiting for response")
 
-	// ErrConnClosed is propagated to Session and Senders/Receivers
-	// when Client.Close() is called.
+	// ErrConnClosed is propagated to Session and Senders/Receivers,
+	// wrapped in a *ConnectionError, when Client.Close() is called.
+	//
+	// As it is wrapped, check for it with errors.Is rather than ==.
 	ErrConnClosed = errors.New("amqp: connection closed")
 )
 
@@ -106,6 +110,9 @@ func ConnMaxFrameSize(n uint32) ConnOption {
 // Once the connection has been established, ConnIdleTimeout
 // applies. If duration is zero, no timeout will be applied.
 //
+// It also bounds how long Client.Close waits for the server to
+// acknowledge the close, which is 5 seconds if duration is zero.
+//
 // Default: 0.
 func ConnConnectTimeout(d time.Duration) ConnOption {
 	return func(c *conn) error { c.connectTimeout = d; return nil }
@@ -151,14 +158,61 @@ func ConnProperty(key, value string) ConnOption {
 func ConnContainerID(id string) ConnOption {
 	return func(c *conn) error {
 		c.containerID = id
+		c.containerIDSet = true
+		return nil
+	}
+}
+
+// ConnOfferedCapabilities adds capabilities offered to the server
+// when the connection is opened.
+//
+// This option can be used multiple times.
+func ConnOfferedCapabilities(capabilities ...string) ConnOption {
+	return func(c *conn) error {
+		for _, capability := range capabilities {
+			c.offeredCapabilities = append(c.offeredCapabilities, symbol(capability))
+		}
+		return nil
+	}
+}
+
+// ConnDesiredCapabilities adds capabilities requested of the server
+// when the connection is opened.
+//
+// Capabilities granted by the server can be checked with
+// Client.CapabilityGranted.
+//
+// This option can be used multiple times.
+func ConnDesiredCapabilities(capabilities ...string) ConnOption {
+	return func(c *conn) error {
+		for _, capability := range capabilities {
+			c.desiredCapabilities = append(c.desiredCapabilities, symbol(capability))
+		}
+		return nil
+	}
+}
+
+// ConnWrapNet sets a function wrapping the network connection, for
+// example to record or inspect the bytes exchanged with the server.
+//
+// wrap is called once the connection is established, before any bytes
+// are sent. With an amqps URL the wrapped connection carries plain
+// text; with ConnTLS, TLS is negotiated over the wrapped connection.
+func ConnWrapNet(wrap func(net.Conn) net.Conn) ConnOption {
+	return func(c *conn) error {
+		if wrap == nil {
+			return errorNew("wrap function must not be nil")
+		}
+		c.wrapNet = wrap
 		return nil
 	}
 }
 
 // conn is an AMQP connection.
 type conn struct {
-	net            net.Conn      // underlying connection
-	connectTimeout time.Duration // time to wait for reads/writes during conn establishment
+	net            net.Conn                // underlying connection
+	wrapNet        func(net.Conn) net.Conn // if set, wraps net before it is used
+	connectTimeout time.Duration           // time to wait for reads/writes during conn establishment
 
 	// TLS
 	tlsNegotiation bool        // negotiate TLS
@@ -170,16 +224,29 @@ type conn struct {
 	saslComplete bool                 // SASL negotiation complete
 
 	// local settings
-	maxFrameSize uint32                 // max frame size to accept
-	channelMax   uint16                 // maximum number of channels to allow
-	hostname     string                 // hostname of remote server (set explicitly or parsed from URL)
-	idleTimeout  time.Duration          // maximum period between receiving frames
-	properties   map[symbol]interface{} // additional properties sent upon connection open
-	containerID  string                 // set explicitly or randomly generated
+	maxFrameSize   uint32                 // max frame size to accept
+	channelMax     uint16                 // maximum number of channels to allow
+	hostname       string                 // hostname of remote server (set explicitly or parsed from URL)
+	idleTimeout    time.Duration          // maximum period between receiving frames
+	properties     map[symbol]interface{} // additional properties sent upon connection open
+	containerID    string                 // set explicitly or randomly generated
+	containerIDSet bool                   // containerID was set explicitly with ConnContainerID
+
+	offeredCapabilities multiSymbol // capabilities sent upon connection open
+	desiredCapabilities multiSymbol // capabilities requested upon connection open
+
+	sendInterceptors    []SendInterceptor           // default interceptors of Senders, outermost first
+	receiveInterceptors []ReceiveInterceptor        // default interceptors of Receivers, outermost first
+	propagator          Propagator                  // default trace context propagator of links
+	traceCarrier        func(*Message) TraceCarrier // carrier of propagator, MessageCarrier if nil
+	metrics             Metrics                     // receives activity callbacks, NopMetrics by default
+	logger              Logger                      // logs activity of the connection, its sessions and links
+	frameTrace          frameTracer                 // writes frames sent and received, if enabled
 
 	// peer settings
 	peerIdleTimeout  time.Duration // maximum period between sending frames
 	peerMaxFrameSize uint32        // maximum frame size peer will accept
+	peerOpen         *performOpen  // open frame received from peer
 
 	// conn state
 	errMu sync.Mutex    // mux holds errMu from start until shutdown completes; operations are sequential before mux is started
@@ -192,6 +259,10 @@ type conn struct {
 	connErr      chan error          // connReader/Writer notifications of an error
 	closeMux     chan struct{}       // indicates that the mux should stop
 	closeMuxOnce sync.Once
+	closeMu      sync.Mutex      // protects closeErr and closeCtx, mux may be closing for another reason when they are set
+	closeErr     *Error          // error sent to the peer in the Close frame, set before closeMux is closed
+	closeCtx     context.Context // if set, mux waits for the peer's Close until closeCtx is done
+	closeCtxErr  error           // set by mux if closeCtx was done before the peer's Close was received
 
 	// connReader
 	rxProto       chan protoHeader // protoHeaders received by connReader
@@ -229,6 +300,8 @@ func newConn(netConn net.Conn, opts ...ConnOption) (*conn, error) {
 		delSession:       make(chan *Session),
 		txFrame:          make(chan frame),
 		txDone:           make(chan struct{}),
+		metrics:          NopMetrics{},
+		logger:           defaultLogger(),
 	}
 
 	// apply options
@@ -253,6 +326,10 @@ func (c *conn) initTLSConfig() {
 }
 
 func (c *conn) start() error {
+	if c.wrapNet != nil {
+		c.net = c.wrapNet(c.net)
+	}
+
 	// start reader
 	go c.connReader()
 
@@ -268,6 +345,13 @@ func (c *conn) start() error {
 		return c.err
 	}
 
+	c.metrics.ConnOpened()
+	c.log(LogInfo, "connection opened")
+
+	// mux holds errMu until shutdown completes, lock before starting it
+	// so Close can't return before the connection is closed
+	c.errMu.Lock()
+
 	// start multiplexor and writer
 	go c.mux()
 	go c.connWriter()
@@ -275,15 +359,74 @@ func (c *conn) start() error {
 	return nil
 }
 
+// startContext is like start, but aborts connection establishment
+// when ctx is done.
+func (c *conn) startContext(ctx context.Context) error {
+	var (
+		netConn = c.net // c.net may be swapped by startTLS
+		started = make(chan struct{})
+		stopped = make(chan struct{})
+	)
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			// interrupt any blocked reads and writes
+			_ = netConn.Close()
+		case <-started:
+		}
+	}()
+
+	err := c.start()
+	close(started)
+	<-stopped
+
+	if ctx.Err() != nil {
+		_ = c.Close()
+		return ctx.Err()
+	}
+	return err
+}
+
+// closeTimeout bounds waiting for the peer's Close in Close when
+// no connect timeout is configured.
+const closeTimeout = 5 * time.Second
+
+// Close closes the connection, waiting for the peer's Close up to the
+// connect timeout, or closeTimeout if it is not set. Not receiving the
+// peer's Close in time is not reported as an error.
 func (c *conn) Close() error {
-	c.closeMuxOnce.Do(func() { close(c.closeMux) })
-	err := c.getErr()
-	if err == ErrConnClosed {
+	timeout := c.connectTimeout
+	if timeout == 0 {
+		timeout = closeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := c.closeWithError(ctx, nil)
+	if errors.Is(err, context.DeadlineExceeded) {
 		return nil
 	}
 	return err
 }
 
+// closeWithError closes the connection, sending e to the peer, and
+// waits for the peer's Close until ctx is done.
+func (c *conn) closeWithError(ctx context.Context, e *Error) error {
+	c.closeMuxOnce.Do(func() {
+		c.closeMu.Lock()
+		c.closeErr = e
+		c.closeCtx = ctx
+		c.closeMu.Unlock()
+		close(c.closeMux)
+	})
+	err := c.getErr()
+	if errors.Is(err, ErrConnClosed) {
+		return c.closeCtxErr
+	}
+	return err
+}
+
 // close should only be called by conn.mux.
 func (c *conn) close() {
 	close(c.done) // notify goroutines and blocked functions to exit
@@ -305,9 +448,19 @@ func (c *conn) close() {
 		c.err = ErrConnClosed
 	}
 
+	if _, ok := c.err.(*ConnectionError); !ok {
+		c.err = &ConnectionError{inner: c.err}
+	}
+
 	// check rxDone after closing net, otherwise may block
 	// for up to c.idleTimeout
 	<-c.rxDone
+
+	if errors.Is(c.err, ErrConnClosed) {
+		c.metrics.ConnClosed(nil)
+	} else {
+		c.metrics.ConnClosed(c.err)
+	}
 }
 
 // getErr returns conn.err.
@@ -333,12 +486,21 @@ func (c *conn) mux() {
 		// map channels to sessions
 		sessionsByChannel       = make(map[uint16]*Session)
 		sessionsByRemoteChannel = make(map[uint16]*Session)
+
+		// set when the peer's Close has been received
+		remoteClosed bool
+		// set when connReader/Writer reported an error, nothing more can be exchanged
+		netFailed bool
 	)
 
-	// hold the errMu lock until error or done
-	c.errMu.Lock()
+	// hold the errMu lock, taken by start, until error or done
 	defer c.errMu.Unlock()
 	defer c.close() // defer order is important. c.errMu unlock indicates that connection is finally complete
+	defer func() {
+		if !netFailed {
+			c.closeHandshake(remoteClosed)
+		}
+	}()
 
 	for {
 		// check if last loop returned an error
@@ -349,6 +511,8 @@ func (c *conn) mux() {
 		select {
 		// error from connReader
 		case c.err = <-c.connErr:
+			netFailed = true
+			c.log(LogError, "connection failed", "error", c.err)
 
 		// new frame from connReader
 		case fr := <-c.rxFrame:
@@ -358,6 +522,18 @@ func (c *conn) mux() {
 			)
 
 			switch body := fr.body.(type) {
+			// peer is closing the connection
+			case *performClose:
+				c.log(LogDebug, "RX", "frame", body)
+				if body.Error != nil {
+					c.log(LogWarn, "connection closed by peer", "error", body.Error)
+				} else {
+					c.log(LogInfo, "connection closed by peer")
+				}
+				c.err = &ConnectionError{RemoteError: body.Error, Remote: true}
+				remoteClosed = true
+				continue
+
 			// RemoteChannel should be used when frame is Begin
 			case *performBegin:
 				session, ok = sessionsByChannel[body.RemoteChannel]
@@ -374,6 +550,7 @@ func (c *conn) mux() {
 
 			if !ok {
 				c.err = errorErrorf("unexpected frame: %#v", fr.body)
+				c.log(LogError, "connection failed", "error", c.err)
 				continue
 			}
 
@@ -422,6 +599,59 @@ func (c *conn) mux() {
 	}
 }
 
+// closeHandshake sends a Close frame to the peer, replying to the peer's
+// Close if remoteClosed is true. Otherwise, if closeCtx is set, it waits
+// for the peer's Close until closeCtx is done.
+//
+// closeHandshake should only be called by conn.mux.
+func (c *conn) closeHandshake(remoteClosed bool) {
+	c.closeMu.Lock()
+	closeErr, closeCtx := c.closeErr, c.closeCtx
+	c.closeMu.Unlock()
+
+	cl := &performClose{Error: closeErr}
+	if cl.Error == nil && c.err != nil && !remoteClosed {
+		// closing due to a local error
+		cl.Error = &Error{
+			Condition:   ErrorInternalError,
+			Description: c.err.Error(),
+		}
+	}
+
+	c.log(LogDebug, "TX", "frame", cl)
+	select {
+	case c.txFrame <- frame{type_: frameTypeAMQP, body: cl}:
+	case <-c.txDone:
+		return
+	}
+
+	if remoteClosed || closeCtx == nil {
+		return
+	}
+
+	for {
+		select {
+		case fr := <-c.rxFrame:
+			body, ok := fr.body.(*performClose)
+			if !ok {
+				// frames for sessions are discarded while closing
+				continue
+			}
+			c.log(LogDebug, "RX", "frame", body)
+			if body.Error != nil {
+				c.err = &ConnectionError{RemoteError: body.Error, Remote: true}
+			}
+			return
+		case <-c.connErr:
+			return
+		case <-closeCtx.Done():
+			c.closeCtxErr = closeCtx.Err()
+			c.log(LogWarn, "timed out waiting for the peer's close", "error", c.closeCtxErr)
+			return
+		}
+	}
+}
+
 // connReader reads from the net.Conn, decodes frames, and passes them
 // up via the conn.rxFrame and conn.rxProto channels.
 func (c *conn) connReader() {
@@ -479,6 +709,8 @@ func (c *conn) connReader() {
 				return
 			}
 
+			c.frameTrace.proto(traceReceived, p)
+
 			// negotiation is complete once an AMQP proto frame is received
 			if p.ProtoID == protoAMQP {
 				negotiating = false
@@ -521,6 +753,8 @@ func (c *conn) connReader() {
 
 		// check if body is empty (keepalive)
 		if bodySize == 0 {
+			c.metrics.FrameReceived("empty", int(currentHeader.Size))
+			c.frameTrace.frame(traceReceived, currentHeader.Channel, nil)
 			continue
 		}
 
@@ -536,6 +770,8 @@ func (c *conn) connReader() {
 			c.connErr <- err
 			return
 		}
+		c.metrics.FrameReceived(frameType(parsedBody), int(currentHeader.Size))
+		c.frameTrace.frame(traceReceived, currentHeader.Channel, parsedBody)
 
 		// send to mux
 		select {
@@ -582,6 +818,10 @@ func (c *conn) connWriter() {
 		// keepalive timer
 		case <-keepalive:
 			_, err = c.net.Write(keepaliveFrame)
+			if err == nil {
+				c.metrics.FrameSent("empty", len(keepaliveFrame))
+				c.frameTrace.frame(traceSent, 0, nil)
+			}
 			// It would be slightly more efficient in terms of network
 			// resources to reset the timer each time a frame is sent.
 			// However, keepalives are small (8 bytes) and the interval
@@ -590,13 +830,8 @@ func (c *conn) connWriter() {
 			// avoid. (To properly reset a timer it needs to be stopped,
 			// possibly drained, then reset.)
 
-		// connection complete
+		// connection complete, the Close frame has been sent by mux
 		case <-c.done:
-			// send close
-			_ = c.writeFrame(frame{
-				type_: frameTypeAMQP,
-				body:  &performClose{},
-			})
 			return
 		}
 	}
@@ -623,7 +858,13 @@ func (c *conn) writeFrame(fr frame) error {
 
 	// write to network
 	_, err = c.net.Write(c.txBuf.bytes())
-	return err
+	if err != nil {
+		return err
+	}
+
+	c.metrics.FrameSent(frameType(fr.body), c.txBuf.len())
+	c.frameTrace.frame(traceSent, fr.channel, fr.body)
+	return nil
 }
 
 // writeProtoHeader writes an AMQP protocol header to the
@@ -633,7 +874,12 @@ func (c *conn) writeProtoHeader(pID protoID) error {
 		_ = c.net.SetWriteDeadline(time.Now().Add(c.connectTimeout))
 	}
 	_, err := c.net.Write([]byte{'A', 'M', 'Q', 'P', byte(pID), 1, 0, 0})
-	return err
+	if err != nil {
+		return err
+	}
+
+	c.frameTrace.proto(traceSent, protoHeader{ProtoID: pID, Major: 1})
+	return nil
 }
 
 // keepaliveFrame is an AMQP frame with no body, used for keepalives
@@ -678,6 +924,19 @@ const (
 	protoSASL protoID = 0x3
 )
 
+func (p protoID) String() string {
+	switch p {
+	case protoAMQP:
+		return "AMQP"
+	case protoTLS:
+		return "TLS"
+	case protoSASL:
+		return "SASL"
+	default:
+		return fmt.Sprintf("%#02x", uint8(p))
+	}
+}
+
 // exchangeProtoHeader performs the round trip exchange of protocol
 // headers, validation, and returns the protoID specific next state.
 func (c *conn) exchangeProtoHeader(pID protoID) stateFunc {
@@ -695,7 +954,7 @@ func (c *conn) exchangeProtoHeader(pID protoID) stateFunc {
 	}
 
 	if pID != p.ProtoID {
-		c.err = errorErrorf("unexpected protocol header %#00x, expected %#00x", p.ProtoID, pID)
+		c.err = errorErrorf("unexpected protocol header %s, expected %s", p.ProtoID, pID)
 		return nil
 	}
 
@@ -708,7 +967,7 @@ func (c *conn) exchangeProtoHeader(pID protoID) stateFunc {
 	case protoSASL:
 		return c.negotiateSASL
 	default:
-		c.err = errorErrorf("unknown protocol ID %#02x", p.ProtoID)
+		c.err = errorErrorf("unknown protocol ID %s", p.ProtoID)
 		return nil
 	}
 }
@@ -781,6 +1040,9 @@ func (c *conn) openAMQP() stateFunc {
 			ChannelMax:   c.channelMax,
 			IdleTimeout:  c.idleTimeout,
 			Properties:   c.properties,
+
+			OfferedCapabilities: c.offeredCapabilities,
+			DesiredCapabilities: c.desiredCapabilities,
 		},
 		channel: 0,
 	})
@@ -801,6 +1063,7 @@ func (c *conn) openAMQP() stateFunc {
 	}
 
 	// update peer settings
+	c.peerOpen = o
 	if o.MaxFrameSize > 0 {
 		c.peerMaxFrameSize = o.MaxFrameSize
 	}
@@ -863,7 +1126,7 @@ func (c *conn) saslOutcome() stateFunc {
 
 	// check if auth succeeded
 	if so.Code != codeSASLOK {
-		c.err = errorErrorf("SASL PLAIN auth failed with code %#00x: %s", so.Code, so.AdditionalData) // implement Stringer for so.Code
+		c.err = errorErrorf("SASL PLAIN auth failed with code %s: %s", so.Code, so.AdditionalData)
 		return nil
 	}
 
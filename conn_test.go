@@ -1,7 +1,12 @@
 package amqp
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/xcvc/amqp/internal/testconn"
 )
 
 func TestConnOptions(t *testing.T) {
@@ -42,3 +47,149 @@ func TestConnOptions(t *testing.T) {
 		})
 	}
 }
+
+func TestConnCloseWaitsForShutdown(t *testing.T) {
+	open := new(buffer)
+	if err := writeFrame(open, frame{type_: frameTypeAMQP, body: &performOpen{ContainerID: "peer"}}); err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte("AMQP\x00\x01\x00\x00SPLIT\n"), open.bytes()...)
+
+	// Close right after New must not return before mux has taken
+	// errMu, so repeat to give the scheduler a chance to interleave.
+	for i := 0; i < 50; i++ {
+		client, err := New(testconn.New(data), ConnIdleTimeout(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the peer never replies to Close, keep the wait for it short
+		client.conn.connectTimeout = time.Millisecond
+		client.Close()
+
+		select {
+		case <-client.conn.done:
+		default:
+			t.Fatalf("Close() returned before the connection was closed")
+		}
+	}
+}
+
+// newClosingPeer starts a peer replying to Open and, if reply is not
+// nil, replying to Close with reply.
+func newClosingPeer(t *testing.T, reply *performClose) (*testPeer, *Client) {
+	peer, netConn := newTestPeer(t, func(body frameBody) []frameBody {
+		switch body.(type) {
+		case *performOpen:
+			return []frameBody{&performOpen{ContainerID: "peer"}}
+		case *performClose:
+			if reply != nil {
+				return []frameBody{reply}
+			}
+		}
+		return nil
+	})
+
+	client, err := New(netConn, ConnIdleTimeout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return peer, client
+}
+
+func TestConnRemoteClose(t *testing.T) {
+	peer, client := newClosingPeer(t, nil)
+
+	remoteErr := &Error{Condition: ErrorInternalError, Description: "shutting down"}
+	if err := peer.send(&performClose{Error: remoteErr}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client replies without an error
+	want := &performClose{}
+	if got := peer.receiveClose(); !testEqual(got, want) {
+		t.Errorf("Close sent by the client does not match expected:\n %s", testDiff(got, want))
+	}
+
+	var connErr *ConnectionError
+	if err := client.Close(); !errors.As(err, &connErr) || !connErr.Remote || !testEqual(connErr.RemoteError, remoteErr) {
+		t.Errorf("Close() error = %v, want a remote *ConnectionError with %v", err, remoteErr)
+	}
+}
+
+func TestConnCloseWithError(t *testing.T) {
+	peer, client := newClosingPeer(t, &performClose{})
+
+	e := &Error{Condition: ErrorNotAllowed, Description: "going away"}
+	if err := client.CloseWithError(context.Background(), e); err != nil {
+		t.Fatalf("CloseWithError() error = %v", err)
+	}
+
+	want := &performClose{Error: e}
+	if got := peer.receiveClose(); !testEqual(got, want) {
+		t.Errorf("Close sent by the client does not match expected:\n %s", testDiff(got, want))
+	}
+}
+
+func TestConnCloseWithErrorWaitsForPeer(t *testing.T) {
+	// the peer's Close carries an error, which is only returned
+	// if CloseWithError waited for it
+	remoteErr := &Error{Condition: ErrorInternalError, Description: "closed"}
+	_, client := newClosingPeer(t, &performClose{Error: remoteErr})
+
+	err := client.CloseWithError(context.Background(), nil)
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) || !testEqual(connErr.RemoteError, remoteErr) {
+		t.Errorf("CloseWithError() error = %v, want a *ConnectionError with %v", err, remoteErr)
+	}
+}
+
+func TestConnCloseWithErrorTimeout(t *testing.T) {
+	// the peer never replies to the client's Close
+	peer, client := newClosingPeer(t, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := client.CloseWithError(ctx, nil); err != context.DeadlineExceeded {
+		t.Errorf("CloseWithError() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if peer.receiveClose() == nil {
+		t.Error("Close not sent to the peer")
+	}
+	select {
+	case <-client.conn.done:
+	default:
+		t.Error("connection not closed after the timeout")
+	}
+}
+
+func TestConnCloseWaitsForPeer(t *testing.T) {
+	// as with CloseWithError, the peer's error is only returned
+	// if Close waited for it
+	remoteErr := &Error{Condition: ErrorInternalError, Description: "closed"}
+	_, client := newClosingPeer(t, &performClose{Error: remoteErr})
+
+	err := client.Close()
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) || !testEqual(connErr.RemoteError, remoteErr) {
+		t.Errorf("Close() error = %v, want a *ConnectionError with %v", err, remoteErr)
+	}
+}
+
+func TestConnCloseTimeout(t *testing.T) {
+	// the peer never replies, Close gives up after the connect timeout
+	peer, client := newClosingPeer(t, nil)
+	client.conn.connectTimeout = 50 * time.Millisecond
+
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if peer.receiveClose() == nil {
+		t.Error("Close not sent to the peer")
+	}
+	select {
+	case <-client.conn.done:
+	default:
+		t.Error("connection not closed after the timeout")
+	}
+}
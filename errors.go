@@ -0,0 +1,115 @@
+package amqp
+
+import (
+	"fmt"
+)
+
+// ConnectionError is returned by operations on a Client, and the Sessions
+// and links opened on it, once the connection has closed.
+//
+// Use errors.Is to check for a specific ErrorCondition sent by the peer,
+// or ErrConnClosed when the connection was closed by Client.Close.
+type ConnectionError struct {
+	// RemoteError is the error sent by the peer when closing the
+	// connection. It is nil if the peer did not send an error.
+	RemoteError *Error
+
+	// Remote is true when the connection was closed by the peer.
+	Remote bool
+
+	inner error // cause of a local close, e.g. ErrConnClosed or a network error
+}
+
+func (e *ConnectionError) Error() string {
+	if e.Remote {
+		return remoteErrorString("amqp: connection closed by peer", e.RemoteError)
+	}
+	if e.inner == nil {
+		return ErrConnClosed.Error()
+	}
+	return e.inner.Error()
+}
+
+// Unwrap returns the underlying cause of the close.
+func (e *ConnectionError) Unwrap() error {
+	return unwrapEndpointError(e.RemoteError, e.inner)
+}
+
+// SessionError is returned by operations on a Session, and the links
+// opened on it, once the session has ended.
+//
+// Use errors.Is to check for a specific ErrorCondition sent by the peer,
+// or ErrSessionClosed when the session was ended by Session.Close.
+type SessionError struct {
+	// RemoteError is the error sent by the peer when ending the
+	// session. It is nil if the peer did not send an error.
+	RemoteError *Error
+
+	// Remote is true when the session was ended by the peer.
+	Remote bool
+
+	inner error // cause of a local end, e.g. ErrSessionClosed
+}
+
+func (e *SessionError) Error() string {
+	if e.Remote {
+		return remoteErrorString("amqp: session ended by peer", e.RemoteError)
+	}
+	if e.inner == nil {
+		return ErrSessionClosed.Error()
+	}
+	return e.inner.Error()
+}
+
+// Unwrap returns the underlying cause of the end.
+func (e *SessionError) Unwrap() error {
+	return unwrapEndpointError(e.RemoteError, e.inner)
+}
+
+// LinkError is returned by operations on a Sender or Receiver once
+// the link has been detached.
+//
+// Use errors.Is to check for a specific ErrorCondition sent by the peer,
+// or ErrLinkClosed when the link was closed by Sender.Close or
+// Receiver.Close. A link detached by the peer also wraps a *DetachError.
+type LinkError struct {
+	// RemoteError is the error sent by the peer when detaching the
+	// link. It is nil if the peer did not send an error.
+	RemoteError *Error
+
+	// Remote is true when the link was detached by the peer.
+	Remote bool
+
+	inner error // cause of the detach, e.g. ErrLinkClosed or *DetachError
+}
+
+func (e *LinkError) Error() string {
+	if e.inner == nil {
+		return remoteErrorString("amqp: link detached by peer", e.RemoteError)
+	}
+	return e.inner.Error()
+}
+
+// Unwrap returns the underlying cause of the detach.
+func (e *LinkError) Unwrap() error {
+	return unwrapEndpointError(e.RemoteError, e.inner)
+}
+
+func remoteErrorString(msg string, remote *Error) string {
+	if remote == nil {
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", msg, remote)
+}
+
+// unwrapEndpointError returns inner if set, otherwise remote. A nil
+// *Error is returned as a nil error rather than a typed nil.
+func unwrapEndpointError(remote *Error, inner error) error {
+	if inner != nil {
+		return inner
+	}
+	if remote != nil {
+		return remote
+	}
+	return nil
+}
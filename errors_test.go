@@ -0,0 +1,68 @@
+package amqp
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEndpointErrors(t *testing.T) {
+	remote := &Error{Condition: ErrorNotFound, Description: "no such queue"}
+
+	tests := []struct {
+		label string
+		err   error
+
+		wantIs    []error
+		wantNotIs []error
+	}{
+		{
+			label:     "remote detach",
+			err:       &LinkError{RemoteError: remote, Remote: true, inner: &DetachError{remote}},
+			wantIs:    []error{ErrorNotFound},
+			wantNotIs: []error{ErrLinkClosed, ErrorNotAllowed},
+		},
+		{
+			label:     "local link close",
+			err:       &LinkError{inner: ErrLinkClosed},
+			wantIs:    []error{ErrLinkClosed},
+			wantNotIs: []error{ErrorNotFound},
+		},
+		{
+			label:     "remote end",
+			err:       &SessionError{RemoteError: remote, Remote: true},
+			wantIs:    []error{ErrorNotFound},
+			wantNotIs: []error{ErrSessionClosed},
+		},
+		{
+			label:     "remote end without error",
+			err:       &SessionError{Remote: true},
+			wantNotIs: []error{ErrSessionClosed, ErrorNotFound},
+		},
+		{
+			label:     "local conn close",
+			err:       &ConnectionError{inner: ErrConnClosed},
+			wantIs:    []error{ErrConnClosed},
+			wantNotIs: []error{ErrorConnectionForced},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			for _, target := range tt.wantIs {
+				if !errors.Is(tt.err, target) {
+					t.Errorf("expected errors.Is(%v, %v)", tt.err, target)
+				}
+			}
+			for _, target := range tt.wantNotIs {
+				if errors.Is(tt.err, target) {
+					t.Errorf("expected !errors.Is(%v, %v)", tt.err, target)
+				}
+			}
+		})
+	}
+
+	var de *DetachError
+	if !errors.As(tests[0].err, &de) || de.RemoteError != remote {
+		t.Errorf("expected errors.As to find *DetachError in %v", tests[0].err)
+	}
+}
@@ -0,0 +1,93 @@
+package amqp
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ConnFrameTrace writes a line to w for every frame sent and received
+// on the connection, similar to PN_TRACE_FRM in Qpid Proton.
+//
+// Each line starts with the direction, "->" for sent and "<-" for
+// received, followed by the channel in brackets and the frame, e.g.:
+//
+//	-> [1] Flow{NextIncomingID: 0, IncomingWindow: 100, ...}
+//
+// Protocol headers, SASL frames and empty keepalive frames are
+// included. The initial response of SASL mechanisms, which may contain
+// credentials, is omitted. Transfer payloads are written in full,
+// unless limited with ConnFrameTracePayload.
+//
+// Lines are written one at a time; errors writing to w are ignored.
+func ConnFrameTrace(w io.Writer) ConnOption {
+	return func(c *conn) error {
+		c.frameTrace.w = w
+		return nil
+	}
+}
+
+// ConnFrameTracePayload limits the payload written by ConnFrameTrace
+// for each transfer to max bytes. If max is 0, payloads are omitted.
+func ConnFrameTracePayload(max int) ConnOption {
+	return func(c *conn) error {
+		if max < 0 {
+			return errorNew("frame trace payload limit must not be negative")
+		}
+		c.frameTrace.maxPayload = max
+		c.frameTrace.limitPayload = true
+		return nil
+	}
+}
+
+// frameTracer writes the frames of a connection, if w is set.
+type frameTracer struct {
+	mu           sync.Mutex
+	w            io.Writer
+	maxPayload   int  // maximum payload bytes written per transfer
+	limitPayload bool // maxPayload is set
+}
+
+// Frame directions.
+const (
+	traceSent     = "->"
+	traceReceived = "<-"
+)
+
+// proto traces a protocol header.
+func (t *frameTracer) proto(dir string, p protoHeader) {
+	if t.w == nil {
+		return
+	}
+	t.println(fmt.Sprintf("%s %s", dir, p))
+}
+
+// frame traces a frame. An empty frame is traced if body is nil.
+func (t *frameTracer) frame(dir string, channel uint16, body frameBody) {
+	if t.w == nil {
+		return
+	}
+
+	if body == nil {
+		t.println(fmt.Sprintf("%s [%d] Empty", dir, channel))
+		return
+	}
+
+	line := fmt.Sprintf("%s [%d] %s", dir, channel, body)
+	if tr, ok := body.(*performTransfer); ok && len(tr.Payload) > 0 {
+		payload := tr.Payload
+		switch {
+		case !t.limitPayload || len(payload) <= t.maxPayload:
+			line += fmt.Sprintf(" Payload: %q", payload)
+		case t.maxPayload > 0:
+			line += fmt.Sprintf(" Payload: %q...", payload[:t.maxPayload])
+		}
+	}
+	t.println(line)
+}
+
+func (t *frameTracer) println(line string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, _ = io.WriteString(t.w, line+"\n")
+}
@@ -0,0 +1,42 @@
+package amqp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFrameTrace(t *testing.T) {
+	var buf bytes.Buffer
+	c, err := newConn(nil, ConnFrameTrace(&buf), ConnFrameTracePayload(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.frameTrace.proto(traceSent, protoHeader{ProtoID: protoSASL, Major: 1})
+	c.frameTrace.frame(traceSent, 0, &saslInit{Mechanism: "PLAIN", InitialResponse: []byte("\x00user\x00secret")})
+	c.frameTrace.frame(traceReceived, 0, &saslOutcome{Code: codeSASLAuth})
+	c.frameTrace.frame(traceReceived, 2, nil)
+	c.frameTrace.frame(traceSent, 1, &performTransfer{Handle: 4, Payload: []byte("hello")})
+	c.frameTrace.frame(traceSent, 1, &performEnd{})
+
+	const want = `-> Header{ProtoID: SASL, Major: 1, Minor: 0, Revision: 0}
+-> [0] SASLInit{Mechanism: PLAIN, InitialResponse [size]: 12, Hostname: }
+<- [0] SASLOutcome{Code: auth, AdditionalData [size]: 0}
+<- [2] Empty
+-> [1] Transfer{Handle: 4, DeliveryID: <nil>, DeliveryTag: "<nil>", MessageFormat: <nil>, Settled: false, More: false, ReceiverSettleMode: <nil>, State: <nil>, Resume: false, Aborted: false, Batchable: false, Payload [size]: 5} Payload: "hel"...
+-> [1] End{Error: *Error(nil)}
+`
+	if got := buf.String(); got != want {
+		t.Errorf("frame trace does not match expected:\n %s", testDiff(got, want))
+	}
+}
+
+func TestFrameTraceDisabled(t *testing.T) {
+	c, err := newConn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// must not panic without a writer
+	c.frameTrace.frame(traceSent, 0, &performEnd{})
+}
@@ -0,0 +1,409 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// handleSettleTimeout bounds settling messages after the ctx passed to
+// Receiver.Handle is done.
+var handleSettleTimeout = 5 * time.Second
+
+// Handler processes messages received by Receiver.Handle.
+//
+// The message is settled according to the returned error: it is accepted
+// when the error is nil, otherwise it is rejected, or modified when
+// configured with HandleModifyOnError. A *Error returned by the handler,
+// or wrapped by the returned error, is sent to the server as the reason
+// for rejection. A *DispositionError settles the message with its
+// Disposition.
+type Handler interface {
+	HandleMessage(ctx context.Context, msg *Message) error
+}
+
+// HandlerFunc adapts an ordinary function to a Handler.
+type HandlerFunc func(ctx context.Context, msg *Message) error
+
+// HandleMessage calls f(ctx, msg).
+func (f HandlerFunc) HandleMessage(ctx context.Context, msg *Message) error {
+	return f(ctx, msg)
+}
+
+// DispositionError is returned by a Handler to settle the message with
+// a specific outcome, such as releasing it.
+type DispositionError struct {
+	Disposition Disposition
+}
+
+func (e *DispositionError) Error() string {
+	return fmt.Sprintf("amqp: message settled with outcome %s", e.Disposition.Outcome)
+}
+
+// HandleOption is a function for configuring Receiver.Handle.
+type HandleOption func(*handleConfig) error
+
+type handleConfig struct {
+	concurrency int          // maximum number of concurrent handlers, 0 means link credit
+	onError     *Disposition // disposition of failed messages, nil to reject
+	groups      bool         // handle messages of the same group in order
+
+	// if set, GroupSequence is checked and out of sequence messages
+	// are passed to onSequenceError instead of the handler
+	onSequenceError func(context.Context, *Message, *GroupSequenceError) error
+}
+
+// HandleConcurrency sets the maximum number of messages handled
+// concurrently.
+//
+// It is limited to the link credit. The concurrency doesn't limit the
+// credit granted to the server: the link requests messages as Handle
+// receives them, so messages beyond the concurrency are prefetched and
+// wait for a free handler.
+//
+// Default: link credit.
+func HandleConcurrency(n int) HandleOption {
+	return func(c *handleConfig) error {
+		if n < 1 {
+			return errorNew("handle concurrency must be at least 1")
+		}
+		c.concurrency = n
+		return nil
+	}
+}
+
+// HandleModifyOnError settles messages whose handler returned an error
+// as modified with delivery-failed set, instead of rejecting them.
+//
+// undeliverableHere indicates that the server must not redeliver
+// the message to this link.
+func HandleModifyOnError(undeliverableHere bool) HandleOption {
+	return func(c *handleConfig) error {
+		c.onError = &Disposition{
+			Outcome:           OutcomeModified,
+			DeliveryFailed:    true,
+			UndeliverableHere: undeliverableHere,
+		}
+		return nil
+	}
+}
+
+// HandleGroups enables ordered processing of message groups.
+//
+// Messages with the same MessageProperties.GroupID are handled one at a
+// time, in the order they were received. Messages of different groups,
+// and messages without a GroupID, are handled concurrently.
+func HandleGroups() HandleOption {
+	return func(c *handleConfig) error {
+		c.groups = true
+		return nil
+	}
+}
+
+// HandleGroupSequenceError enables ordered processing of message groups,
+// as with HandleGroups, and checks the MessageProperties.GroupSequence of
+// each grouped message.
+//
+// The first message received for a group sets the expected sequence.
+// A message with an unexpected sequence is passed to f instead of the
+// handler, and is settled by the error f returns. After a gap, the
+// sequence continues from the received message; after a duplicate,
+// the expected sequence is unchanged.
+//
+// The sequence of every group is retained for the duration of Handle.
+func HandleGroupSequenceError(f func(ctx context.Context, msg *Message, err *GroupSequenceError) error) HandleOption {
+	return func(c *handleConfig) error {
+		if f == nil {
+			return errorNew("group sequence error function must not be nil")
+		}
+		c.groups = true
+		c.onSequenceError = f
+		return nil
+	}
+}
+
+// GroupSequenceError describes a grouped message whose GroupSequence
+// is not the next in its group.
+type GroupSequenceError struct {
+	GroupID  string
+	Expected uint32 // next sequence number in the group
+	Received uint32 // sequence number of the message
+}
+
+func (e *GroupSequenceError) Error() string {
+	kind := "gap"
+	if e.Duplicate() {
+		kind = "duplicate"
+	}
+	return fmt.Sprintf("amqp: group %q sequence %s: expected %d, received %d", e.GroupID, kind, e.Expected, e.Received)
+}
+
+// Duplicate reports whether the message precedes the expected sequence
+// number, indicating it has already been received. Otherwise, one or
+// more messages of the group are missing.
+//
+// Sequence numbers are compared using RFC-1982 serial number arithmetic.
+func (e *GroupSequenceError) Duplicate() bool {
+	return int32(e.Received-e.Expected) < 0
+}
+
+// groupSequences tracks the next expected sequence number of each group.
+type groupSequences map[string]uint32
+
+// check records the sequence of a grouped message, returning an error
+// if it isn't the next in its group.
+func (g groupSequences) check(groupID string, seq uint32) *GroupSequenceError {
+	expected, ok := g[groupID]
+	if !ok || seq == expected {
+		g[groupID] = seq + 1
+		return nil
+	}
+
+	err := &GroupSequenceError{GroupID: groupID, Expected: expected, Received: seq}
+	if !err.Duplicate() {
+		// resynchronize after a gap
+		g[groupID] = seq + 1
+	}
+	return err
+}
+
+// handleItem is a received message waiting to be handled.
+type handleItem struct {
+	msg    *Message
+	seqErr *GroupSequenceError
+}
+
+// Handle receives messages and calls handler for each of them, settling
+// each message by the handler's return value.
+//
+// Up to the configured concurrency, messages are handled in parallel
+// goroutines. Messages are received while fewer than link credit
+// messages are waiting for a handler or being handled.
+// See HandleGroups for ordered processing of message groups.
+//
+// If a Propagator was configured with ConnTracePropagator or
+// LinkTracePropagator, the trace context of each message is extracted
+// into the ctx passed to the handler.
+//
+// When ctx is done, Handle stops receiving, waits for running handlers
+// to return and returns ctx.Err(). Messages whose handler failed after
+// ctx was done are released rather than rejected. Messages are still
+// settled once ctx is done, for up to five seconds. If receiving or
+// settling fails, Handle returns the error once running handlers return.
+//
+// An error is returned if the Receiver was configured with a link
+// credit of zero.
+func (r *Receiver) Handle(ctx context.Context, handler Handler, opts ...HandleOption) error {
+	var cfg handleConfig
+	for _, opt := range opts {
+		err := opt(&cfg)
+		if err != nil {
+			return err
+		}
+	}
+
+	// messages are received until credit messages are pending, without
+	// credit nothing could be received
+	if r.maxCredit == 0 {
+		return errorNew("Handle requires a link credit greater than zero")
+	}
+	credit := int(r.maxCredit)
+	if cfg.concurrency == 0 || cfg.concurrency > credit {
+		cfg.concurrency = credit
+	}
+
+	var (
+		// slots is held by running handlers, pending by messages
+		// received and not yet settled, including queued ones
+		slots   = make(chan struct{}, cfg.concurrency)
+		pending = make(chan struct{}, credit)
+		wg      sync.WaitGroup
+		errOnce sync.Once
+		failed  = make(chan struct{})
+		err     error
+
+		// queued messages of groups being handled, owned by groupsMu
+		groups    = make(map[string][]handleItem)
+		groupsMu  sync.Mutex
+		sequences = make(groupSequences)
+	)
+	// settleCtx has the values of ctx, and is canceled
+	// handleSettleTimeout after ctx is done
+	settleCtx, cancelSettle := context.WithCancel(valuesContext{ctx})
+	defer cancelSettle()
+
+	fail := func(e error) {
+		errOnce.Do(func() {
+			err = e
+			close(failed)
+		})
+	}
+
+	// handle waits for a free slot, calls the handler for item and
+	// settles the message, then frees the slot.
+	handle := func(item handleItem) {
+		slots <- struct{}{}
+		defer func() {
+			<-slots
+			<-pending
+		}()
+
+		hctx := item.msg.ExtractTraceContext(ctx)
+
+		var herr error
+		if item.seqErr != nil {
+			herr = cfg.onSequenceError(hctx, item.msg, item.seqErr)
+		} else {
+			herr = handler.HandleMessage(hctx, item.msg)
+		}
+
+		// ctx may be done, settle regardless
+		serr := r.settleHandled(settleCtx, item.msg, herr, ctx.Err() != nil, cfg.onError)
+		if serr != nil {
+			fail(serr)
+		}
+	}
+
+	// handleGroup handles the queued messages of a group until
+	// the queue is empty.
+	handleGroup := func(groupID string) {
+		defer wg.Done()
+		for {
+			groupsMu.Lock()
+			queue := groups[groupID]
+			if len(queue) == 0 {
+				delete(groups, groupID)
+				groupsMu.Unlock()
+				return
+			}
+			item := queue[0]
+			groups[groupID] = queue[1:]
+			groupsMu.Unlock()
+
+			handle(item)
+		}
+	}
+
+Loop:
+	for {
+		// wait until fewer than credit messages are pending
+		select {
+		case pending <- struct{}{}:
+		case <-ctx.Done():
+			break Loop
+		case <-failed:
+			break Loop
+		}
+
+		msg, rerr := r.Receive(ctx)
+		if rerr != nil {
+			<-pending
+			if ctx.Err() == nil {
+				fail(rerr)
+			}
+			break Loop
+		}
+
+		item := handleItem{msg: msg}
+
+		if !cfg.groups || msg.Properties == nil || msg.Properties.GroupID == "" {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handle(item)
+			}()
+			continue
+		}
+
+		groupID := msg.Properties.GroupID
+		if cfg.onSequenceError != nil {
+			item.seqErr = sequences.check(groupID, msg.Properties.GroupSequence)
+		}
+
+		// queue the message, starting a goroutine for the group if
+		// it isn't already being handled
+		groupsMu.Lock()
+		queue, active := groups[groupID]
+		groups[groupID] = append(queue, item)
+		groupsMu.Unlock()
+
+		if !active {
+			wg.Add(1)
+			go handleGroup(groupID)
+		}
+	}
+
+	var (
+		handled       = make(chan struct{})
+		settleTimeout = handleSettleTimeout
+	)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-handled:
+			return
+		}
+		timer := time.NewTimer(settleTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancelSettle()
+		case <-handled:
+		}
+	}()
+
+	wg.Wait()
+	close(handled)
+
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// valuesContext has the values of a parent context, without its
+// deadline and cancellation.
+type valuesContext struct {
+	parent context.Context
+}
+
+func (valuesContext) Deadline() (deadline time.Time, ok bool) { return }
+func (valuesContext) Done() <-chan struct{}                   { return nil }
+func (valuesContext) Err() error                              { return nil }
+
+func (c valuesContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+// settleHandled settles msg according to the error returned by its
+// handler. If canceled is true, a failed message is released.
+func (r *Receiver) settleHandled(ctx context.Context, msg *Message, herr error, canceled bool, onError *Disposition) error {
+	var dispErr *DispositionError
+	switch {
+	case herr == nil:
+		return msg.AcceptContext(ctx)
+	case errors.As(herr, &dispErr):
+		return r.Settle(ctx, []*Message{msg}, dispErr.Disposition)
+	case canceled:
+		return msg.ReleaseContext(ctx)
+	case onError != nil:
+		return msg.ModifyContext(ctx, onError.DeliveryFailed, onError.UndeliverableHere, onError.MessageAnnotations)
+	default:
+		return msg.RejectContext(ctx, rejectionError(herr))
+	}
+}
+
+// rejectionError returns the *Error sent to the server when a
+// handler fails with err.
+func rejectionError(err error) *Error {
+	var amqpErr *Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr
+	}
+	return &Error{
+		Condition:   ErrorInternalError,
+		Description: err.Error(),
+	}
+}
@@ -0,0 +1,291 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReceiverHandle(t *testing.T) {
+	const total = 20
+
+	r := &Receiver{
+		maxCredit: 4,
+		link: &link{
+			messages: make(chan Message, total),
+			done:     make(chan struct{}),
+		},
+	}
+	for i := 0; i < total; i++ {
+		// pre-settled, no dispositions are sent
+		r.link.messages <- Message{deliveryID: uint32(i), settled: true}
+	}
+
+	var (
+		running, maxRunning, handled int32
+		allHandled                   = make(chan struct{})
+	)
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		if atomic.AddInt32(&handled, 1) == total {
+			close(allHandled)
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-allHandled
+		cancel()
+	}()
+
+	err := r.Handle(ctx, handler, HandleConcurrency(10))
+	if err != context.Canceled {
+		t.Errorf("Handle() error = %v, want %v", err, context.Canceled)
+	}
+	if handled != total {
+		t.Errorf("handled %d messages, want %d", handled, total)
+	}
+	if maxRunning > 4 {
+		t.Errorf("%d handlers ran concurrently, want at most the link credit (4)", maxRunning)
+	}
+}
+
+func TestReceiverHandleSettleTimeout(t *testing.T) {
+	defer func(d time.Duration) { handleSettleTimeout = d }(handleSettleTimeout)
+	handleSettleTimeout = 20 * time.Millisecond
+
+	// nothing reads the dispositions, settling blocks until canceled
+	r := &Receiver{
+		maxCredit:    1,
+		batching:     true,
+		dispositions: make(chan messageDisposition),
+		link: &link{
+			messages: make(chan Message, 1),
+			done:     make(chan struct{}),
+		},
+	}
+	r.link.messages <- Message{deliveryID: 1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error {
+		cancel()
+		return nil
+	})
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.Handle(ctx, handler)
+	}()
+
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Errorf("Handle() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Handle() did not return after the settle timeout")
+	}
+}
+
+func TestReceiverHandleZeroCredit(t *testing.T) {
+	r := &Receiver{maxCredit: 0, link: &link{done: make(chan struct{})}}
+
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error { return nil })
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Handle(ctx, handler); err == nil || err == ctx.Err() {
+		t.Errorf("Handle() error = %v, want an error for zero credit", err)
+	}
+}
+
+func TestRejectionError(t *testing.T) {
+	amqpErr := &Error{Condition: ErrorDecodeError, Description: "bad payload"}
+	if got := rejectionError(errorWrapf(amqpErr, "handling message")); got != amqpErr {
+		t.Errorf("rejectionError() = %v, want %v", got, amqpErr)
+	}
+
+	want := &Error{Condition: ErrorInternalError, Description: "boom"}
+	if got := rejectionError(errors.New("boom")); !testEqual(got, want) {
+		t.Errorf("rejectionError() does not match expected:\n %s", testDiff(got, want))
+	}
+}
+
+func TestReceiverHandleGroups(t *testing.T) {
+	msgs := []Message{
+		{Properties: &MessageProperties{GroupID: "a", GroupSequence: 0}},
+		{Properties: &MessageProperties{GroupID: "b", GroupSequence: 7}},
+		{Properties: &MessageProperties{GroupID: "a", GroupSequence: 1}},
+		{Properties: &MessageProperties{GroupID: "a", GroupSequence: 1}}, // duplicate
+		{Properties: &MessageProperties{GroupID: "b", GroupSequence: 9}}, // gap
+		{Properties: &MessageProperties{GroupID: "a", GroupSequence: 2}},
+		{},
+	}
+
+	r := &Receiver{
+		maxCredit: 4,
+		link: &link{
+			messages: make(chan Message, len(msgs)),
+			done:     make(chan struct{}),
+		},
+	}
+	for i, msg := range msgs {
+		msg.deliveryID = uint32(i)
+		msg.settled = true
+		r.link.messages <- msg
+	}
+
+	var (
+		mu        sync.Mutex
+		seen      = make(map[string][]uint32)
+		seqErrs   []*GroupSequenceError
+		processed int
+		done      = make(chan struct{})
+	)
+	record := func() {
+		processed++
+		if processed == len(msgs) {
+			close(done)
+		}
+	}
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if msg.Properties != nil {
+			seen[msg.Properties.GroupID] = append(seen[msg.Properties.GroupID], msg.Properties.GroupSequence)
+		}
+		record()
+		return nil
+	})
+	onSeqErr := func(ctx context.Context, msg *Message, err *GroupSequenceError) error {
+		mu.Lock()
+		defer mu.Unlock()
+		seqErrs = append(seqErrs, err)
+		record()
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-done
+		cancel()
+	}()
+	if err := r.Handle(ctx, handler, HandleGroupSequenceError(onSeqErr)); err != context.Canceled {
+		t.Fatalf("Handle() error = %v, want %v", err, context.Canceled)
+	}
+
+	wantSeen := map[string][]uint32{"a": {0, 1, 2}, "b": {7}}
+	if !testEqual(seen, wantSeen) {
+		t.Errorf("handled messages don't match expected:\n %s", testDiff(seen, wantSeen))
+	}
+
+	if len(seqErrs) != 2 {
+		t.Fatalf("got %d sequence errors, want 2", len(seqErrs))
+	}
+	for _, err := range seqErrs {
+		switch err.GroupID {
+		case "a":
+			if !err.Duplicate() || err.Expected != 2 || err.Received != 1 {
+				t.Errorf("unexpected sequence error: %v", err)
+			}
+		case "b":
+			if err.Duplicate() || err.Expected != 8 || err.Received != 9 {
+				t.Errorf("unexpected sequence error: %v", err)
+			}
+		}
+	}
+}
+
+func TestReceiverHandleBlockedGroup(t *testing.T) {
+	// group a's queued message must not hold a slot, leaving
+	// the second slot to group b while a is blocked
+	msgs := []Message{
+		{Properties: &MessageProperties{GroupID: "a"}},
+		{Properties: &MessageProperties{GroupID: "a"}},
+		{Properties: &MessageProperties{GroupID: "b"}},
+	}
+
+	r := &Receiver{
+		maxCredit: 4,
+		link: &link{
+			messages: make(chan Message, len(msgs)),
+			done:     make(chan struct{}),
+		},
+	}
+	for i, msg := range msgs {
+		msg.deliveryID = uint32(i)
+		msg.settled = true
+		r.link.messages <- msg
+	}
+
+	var (
+		release = make(chan struct{})
+		handled = make(chan string, len(msgs))
+	)
+	handler := HandlerFunc(func(ctx context.Context, msg *Message) error {
+		if msg.Properties.GroupID == "a" {
+			<-release
+		}
+		handled <- msg.Properties.GroupID
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- r.Handle(ctx, handler, HandleGroups(), HandleConcurrency(2))
+	}()
+
+	select {
+	case groupID := <-handled:
+		if groupID != "b" {
+			t.Errorf("handled group %q while a is blocked, want b", groupID)
+		}
+	case <-time.After(time.Second):
+		t.Error("group b stalled by blocked group a")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if groupID := <-handled; groupID != "a" {
+			t.Errorf("handled group %q, want a", groupID)
+		}
+	}
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Errorf("Handle() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGroupSequenceDuplicate(t *testing.T) {
+	tests := []struct {
+		expected, received uint32
+		duplicate          bool
+	}{
+		{expected: 5, received: 4, duplicate: true},
+		{expected: 5, received: 6},
+		{expected: 0, received: math.MaxUint32, duplicate: true},
+		{expected: math.MaxUint32, received: 1},
+	}
+
+	for _, tt := range tests {
+		err := &GroupSequenceError{Expected: tt.expected, Received: tt.received}
+		if got := err.Duplicate(); got != tt.duplicate {
+			t.Errorf("Duplicate() with expected %d, received %d = %t, want %t", tt.expected, tt.received, got, tt.duplicate)
+		}
+	}
+}
@@ -1,7 +1,12 @@
 package amqp
 
 import (
+	"encoding/binary"
+	"io"
+	"net"
 	"reflect"
+	"sync"
+	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -63,3 +68,157 @@ func structTypes(v reflect.Value, m map[reflect.Type]struct{}) {
 		}
 	}
 }
+
+// newTestConn returns a conn that isn't connected to a peer. Frames
+// written by its sessions and links are sent on frames, instead of the
+// network, until stop is called.
+func newTestConn(t *testing.T, opts ...ConnOption) (c *conn, frames <-chan frame, stop func()) {
+	t.Helper()
+
+	c, err := newConn(nil, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan frame, 100)
+	go func() {
+		for fr := range c.txFrame {
+			written <- fr
+			if fr.done != nil {
+				close(fr.done)
+			}
+		}
+	}()
+	return c, written, func() { close(c.txFrame) }
+}
+
+// testPeer is a scripted AMQP peer, without SASL, on one end of a
+// net.Pipe.
+type testPeer struct {
+	t        *testing.T
+	conn     net.Conn
+	respond  func(frameBody) []frameBody // returns the replies to a frame received from the client
+	received chan frameBody              // frames received from the client, closed when the client disconnects
+
+	mu sync.Mutex // serializes writes
+}
+
+// newTestPeer starts a peer replying to the client's frames with
+// respond. It returns the client end of the connection.
+func newTestPeer(t *testing.T, respond func(frameBody) []frameBody) (*testPeer, net.Conn) {
+	clientConn, peerConn := net.Pipe()
+	p := &testPeer{
+		t:        t,
+		conn:     peerConn,
+		respond:  respond,
+		received: make(chan frameBody, 100),
+	}
+	go p.serve()
+	return p, clientConn
+}
+
+func (p *testPeer) serve() {
+	defer close(p.received)
+	defer p.conn.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(p.conn, header); err != nil {
+		return
+	}
+	p.mu.Lock()
+	_, err := p.conn.Write([]byte{'A', 'M', 'Q', 'P', 0, 1, 0, 0})
+	p.mu.Unlock()
+	if err != nil {
+		return
+	}
+
+	for {
+		if _, err := io.ReadFull(p.conn, header); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint32(header)-uint32(len(header)))
+		if _, err := io.ReadFull(p.conn, b); err != nil {
+			return
+		}
+		if len(b) == 0 {
+			continue // keepalive
+		}
+
+		body, err := parseFrameBody(&buffer{b: b})
+		if err != nil {
+			p.t.Errorf("peer received invalid frame: %v", err)
+			return
+		}
+		p.received <- body
+		if err := p.send(p.respond(body)...); err != nil {
+			return
+		}
+	}
+}
+
+// send writes frames to the client on channel 0.
+func (p *testPeer) send(bodies ...frameBody) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, body := range bodies {
+		buf := new(buffer)
+		if err := writeFrame(buf, frame{type_: frameTypeAMQP, body: body}); err != nil {
+			return err
+		}
+		if _, err := p.conn.Write(buf.bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveClose returns the next Close received by the peer,
+// discarding other frames, or nil if the client disconnected.
+func (p *testPeer) receiveClose() *performClose {
+	for body := range p.received {
+		if cl, ok := body.(*performClose); ok {
+			return cl
+		}
+	}
+	return nil
+}
+
+// startTestLink creates a link on s, with manual credit so that no flow
+// is sent, and starts its mux.
+func startTestLink(t *testing.T, s *Session, r *Receiver, opts ...LinkOption) *link {
+	t.Helper()
+
+	l, err := newLink(s, r, append([]LinkOption{linkManualCredit()}, opts...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != nil {
+		r.link = l
+		l.messages = make(chan Message, r.maxCredit)
+	}
+	l.rx = make(chan frameBody, 1)
+	go l.mux()
+	return l
+}
+
+// respondDetach acts as the peer of l, replying to its detach with
+// reply, if not nil, and deallocating the link's handle. The detach
+// sent by l is returned on the channel.
+func respondDetach(l *link, reply *performDetach) <-chan *performDetach {
+	detached := make(chan *performDetach, 1)
+	go func() {
+		for fr := range l.session.tx {
+			if fr, ok := fr.(*performDetach); ok {
+				detached <- fr
+				break
+			}
+		}
+		if reply != nil {
+			reply.Handle = l.handle
+			l.rx <- reply
+		}
+		<-l.session.deallocateHandle
+	}()
+	return detached
+}
@@ -0,0 +1,166 @@
+package amqp
+
+import (
+	"time"
+)
+
+// PeerInfo contains the connection settings sent by the peer
+// in its Open frame.
+type PeerInfo struct {
+	ContainerID         string
+	Hostname            string
+	MaxFrameSize        uint32
+	ChannelMax          uint16
+	IdleTimeout         time.Duration
+	OfferedCapabilities []string
+	DesiredCapabilities []string
+	Properties          map[string]interface{}
+}
+
+// PeerInfo returns the connection settings sent by the peer.
+func (c *Client) PeerInfo() PeerInfo {
+	o := c.conn.peerOpen
+	return PeerInfo{
+		ContainerID:         o.ContainerID,
+		Hostname:            o.Hostname,
+		MaxFrameSize:        o.MaxFrameSize,
+		ChannelMax:          o.ChannelMax,
+		IdleTimeout:         o.IdleTimeout,
+		OfferedCapabilities: symbolsToStrings(o.OfferedCapabilities),
+		DesiredCapabilities: symbolsToStrings(o.DesiredCapabilities),
+		Properties:          stringKeys(o.Properties),
+	}
+}
+
+// SessionInfo contains the local session settings and the values
+// sent by the peer in its Begin frame.
+type SessionInfo struct {
+	Channel        uint16
+	IncomingWindow uint32
+	OutgoingWindow uint32
+	HandleMax      uint32
+
+	RemoteChannel             uint16
+	RemoteIncomingWindow      uint32 // as sent in Begin, not updated by flow frames
+	RemoteOutgoingWindow      uint32 // as sent in Begin, not updated by flow frames
+	RemoteHandleMax           uint32
+	RemoteOfferedCapabilities []string
+	RemoteDesiredCapabilities []string
+	RemoteProperties          map[string]interface{}
+}
+
+// Info returns the local and remote session settings.
+func (s *Session) Info() SessionInfo {
+	b := s.remoteBegin
+	return SessionInfo{
+		Channel:                   s.channel,
+		IncomingWindow:            s.incomingWindow,
+		OutgoingWindow:            s.outgoingWindow,
+		HandleMax:                 s.handleMax,
+		RemoteChannel:             s.remoteChannel,
+		RemoteIncomingWindow:      b.IncomingWindow,
+		RemoteOutgoingWindow:      b.OutgoingWindow,
+		RemoteHandleMax:           b.HandleMax,
+		RemoteOfferedCapabilities: symbolsToStrings(b.OfferedCapabilities),
+		RemoteDesiredCapabilities: symbolsToStrings(b.DesiredCapabilities),
+		RemoteProperties:          stringKeys(b.Properties),
+	}
+}
+
+// LinkInfo contains the negotiated link settings and the values
+// sent by the peer in its Attach frame.
+type LinkInfo struct {
+	Name               string
+	Handle             uint32
+	SenderSettleMode   SenderSettleMode   // negotiated
+	ReceiverSettleMode ReceiverSettleMode // negotiated
+	MaxMessageSize     uint64             // negotiated, zero indicates no limit
+
+	RemoteHandle               uint32
+	RemoteMaxMessageSize       uint64
+	RemoteInitialDeliveryCount uint32
+	RemoteSource               *Terminus
+	RemoteTarget               *Terminus
+	RemoteOfferedCapabilities  []string
+	RemoteDesiredCapabilities  []string
+	RemoteProperties           map[string]interface{}
+}
+
+// Info returns the negotiated and remote settings of the Sender's link.
+func (s *Sender) Info() LinkInfo {
+	return s.link.info()
+}
+
+// Info returns the negotiated and remote settings of the Receiver's link.
+func (r *Receiver) Info() LinkInfo {
+	return r.link.info()
+}
+
+func (l *link) info() LinkInfo {
+	// spec defaults when settle modes aren't sent
+	var (
+		sndSettleMode = ModeMixed
+		rcvSettleMode = ModeFirst
+	)
+	if l.senderSettleMode != nil {
+		sndSettleMode = *l.senderSettleMode
+	}
+	if l.receiverSettleMode != nil {
+		rcvSettleMode = *l.receiverSettleMode
+	}
+
+	a := l.remoteAttach
+	return LinkInfo{
+		Name:                       l.name,
+		Handle:                     l.handle,
+		SenderSettleMode:           sndSettleMode,
+		ReceiverSettleMode:         rcvSettleMode,
+		MaxMessageSize:             l.maxMessageSize,
+		RemoteHandle:               a.Handle,
+		RemoteMaxMessageSize:       a.MaxMessageSize,
+		RemoteInitialDeliveryCount: a.InitialDeliveryCount,
+		RemoteSource:               a.Source.terminus(),
+		RemoteTarget:               a.Target.terminus(),
+		RemoteOfferedCapabilities:  symbolsToStrings(a.OfferedCapabilities),
+		RemoteDesiredCapabilities:  symbolsToStrings(a.DesiredCapabilities),
+		RemoteProperties:           stringKeys(a.Properties),
+	}
+}
+
+// CapabilityGranted reports whether the server offered capability
+// when the connection was opened.
+//
+// Servers grant capabilities requested with ConnDesiredCapabilities by
+// including them in their offered capabilities.
+func (c *Client) CapabilityGranted(capability string) bool {
+	return c.conn.peerOpen.OfferedCapabilities.contains(symbol(capability))
+}
+
+// CapabilityGranted reports whether the server offered capability
+// when the session began.
+func (s *Session) CapabilityGranted(capability string) bool {
+	return s.remoteBegin.OfferedCapabilities.contains(symbol(capability))
+}
+
+// CapabilityGranted reports whether the server offered capability
+// when the link was attached.
+func (s *Sender) CapabilityGranted(capability string) bool {
+	return s.link.remoteAttach.OfferedCapabilities.contains(symbol(capability))
+}
+
+// CapabilityGranted reports whether the server offered capability
+// when the link was attached.
+func (r *Receiver) CapabilityGranted(capability string) bool {
+	return r.link.remoteAttach.OfferedCapabilities.contains(symbol(capability))
+}
+
+func stringKeys(m map[symbol]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	mm := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		mm[string(k)] = v
+	}
+	return mm
+}
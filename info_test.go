@@ -0,0 +1,116 @@
+package amqp
+
+import (
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	_, netConn := newTestPeer(t, func(body frameBody) []frameBody {
+		switch body := body.(type) {
+		case *performOpen:
+			return []frameBody{&performOpen{
+				ContainerID:         "peer",
+				Hostname:            "broker",
+				MaxFrameSize:        4096,
+				ChannelMax:          9,
+				OfferedCapabilities: multiSymbol{"ANONYMOUS-RELAY"},
+				Properties:          map[symbol]interface{}{"product": "test"},
+			}}
+		case *performBegin:
+			return []frameBody{&performBegin{
+				RemoteChannel:       0,
+				IncomingWindow:      100,
+				OutgoingWindow:      200,
+				HandleMax:           7,
+				OfferedCapabilities: multiSymbol{"session-cap"},
+			}}
+		case *performAttach:
+			return []frameBody{&performAttach{
+				Name:                body.Name,
+				Handle:              5,
+				Role:                roleReceiver,
+				Target:              &target{Address: "queue"},
+				MaxMessageSize:      1024,
+				DesiredCapabilities: multiSymbol{"link-cap"},
+			}}
+		case *performClose:
+			return []frameBody{&performClose{}}
+		}
+		return nil
+	})
+
+	client, err := New(netConn, ConnIdleTimeout(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	wantPeer := PeerInfo{
+		ContainerID:         "peer",
+		Hostname:            "broker",
+		MaxFrameSize:        4096,
+		ChannelMax:          9,
+		OfferedCapabilities: []string{"ANONYMOUS-RELAY"},
+		Properties:          map[string]interface{}{"product": "test"},
+	}
+	if got := client.PeerInfo(); !testEqual(got, wantPeer) {
+		t.Errorf("PeerInfo() does not match expected:\n %s", testDiff(got, wantPeer))
+	}
+
+	session, err := client.NewSession(SessionIncomingWindow(10), SessionOutgoingWindow(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSession := SessionInfo{
+		Channel:                   0,
+		IncomingWindow:            10,
+		OutgoingWindow:            20,
+		HandleMax:                 session.handleMax,
+		RemoteChannel:             0,
+		RemoteIncomingWindow:      100,
+		RemoteOutgoingWindow:      200,
+		RemoteHandleMax:           7,
+		RemoteOfferedCapabilities: []string{"session-cap"},
+	}
+	if got := session.Info(); !testEqual(got, wantSession) {
+		t.Errorf("Session.Info() does not match expected:\n %s", testDiff(got, wantSession))
+	}
+
+	sender, err := session.NewSender(LinkName("info"), LinkTargetAddress("queue"), LinkMaxMessageSize(2048))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantLink := LinkInfo{
+		Name:                      "info",
+		Handle:                    0,
+		SenderSettleMode:          ModeMixed,
+		ReceiverSettleMode:        ModeFirst,
+		MaxMessageSize:            1024,
+		RemoteHandle:              5,
+		RemoteMaxMessageSize:      1024,
+		RemoteTarget:              &Terminus{Address: "queue", ExpiryPolicy: ExpirySessionEnd},
+		RemoteDesiredCapabilities: []string{"link-cap"},
+	}
+	if got := sender.Info(); !testEqual(got, wantLink) {
+		t.Errorf("Sender.Info() does not match expected:\n %s", testDiff(got, wantLink))
+	}
+}
+
+func TestSourceTerminusFilters(t *testing.T) {
+	s := &source{Filter: filter{
+		"selector":      nil,
+		"apache.org":    nil,
+		"session-id":    nil,
+		"x-opt-offset":  nil,
+		"correlation":   nil,
+		"message-group": nil,
+	}}
+
+	want := []string{"apache.org", "correlation", "message-group", "selector", "session-id", "x-opt-offset"}
+	// map iteration order varies, the filters must not
+	for i := 0; i < 20; i++ {
+		if got := s.terminus().Filters; !testEqual(got, want) {
+			t.Fatalf("Filters don't match expected:\n %s", testDiff(got, want))
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"math/rand"
@@ -759,7 +760,7 @@ func TestIntegrationClose(t *testing.T) {
 		go testClose(t, receiver.Close)
 
 		_, err = receiver.Receive(context.Background())
-		if err != amqp.ErrLinkClosed {
+		if !errors.Is(err, amqp.ErrLinkClosed) {
 			t.Fatalf("Expected ErrLinkClosed from receiver.Receiver, got: %+v", err)
 			return
 		}
@@ -797,7 +798,7 @@ func TestIntegrationClose(t *testing.T) {
 		go testClose(t, session.Close)
 
 		_, err = receiver.Receive(context.Background())
-		if err != amqp.ErrSessionClosed {
+		if !errors.Is(err, amqp.ErrSessionClosed) {
 			t.Fatalf("Expected ErrSessionClosed from receiver.Receiver, got: %+v", err)
 			return
 		}
@@ -840,7 +841,7 @@ func TestIntegrationClose(t *testing.T) {
 		}()
 
 		_, err = receiver.Receive(context.Background())
-		if err != amqp.ErrConnClosed {
+		if !errors.Is(err, amqp.ErrConnClosed) {
 			t.Fatalf("Expected ErrConnClosed from receiver.Receiver, got: %+v", err)
 			return
 		}
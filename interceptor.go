@@ -0,0 +1,160 @@
+package amqp
+
+import (
+	"context"
+)
+
+// SendInvoker sends a message. It is passed to a SendInterceptor
+// to continue sending.
+type SendInvoker func(ctx context.Context, msg *Message) error
+
+// SendInterceptor intercepts Sender.Send.
+//
+// An interceptor may inspect or modify ctx and msg before calling next,
+// and inspect the result. It must call next to send the message, or
+// return an error without sending it.
+type SendInterceptor func(ctx context.Context, msg *Message, next SendInvoker) error
+
+// ReceiveInvoker receives a message. It is passed to a ReceiveInterceptor
+// to continue receiving.
+type ReceiveInvoker func(ctx context.Context) (*Message, error)
+
+// ReceiveInterceptor intercepts Receiver.Receive.
+//
+// An interceptor calls next to receive the next message, and may inspect
+// or modify it before returning it, or return an error instead.
+type ReceiveInterceptor func(ctx context.Context, next ReceiveInvoker) (*Message, error)
+
+// Interceptors are chained in the order they are configured: those of
+// the Client run first, then those of the Session, then those of the
+// link. Each is called by the preceding interceptor's next.
+
+// ConnSendInterceptor adds an interceptor to every Sender
+// opened on the connection.
+func ConnSendInterceptor(i SendInterceptor) ConnOption {
+	return func(c *conn) error {
+		if i == nil {
+			return errorNew("send interceptor must not be nil")
+		}
+		c.sendInterceptors = append(c.sendInterceptors, i)
+		return nil
+	}
+}
+
+// ConnReceiveInterceptor adds an interceptor to every Receiver
+// opened on the connection.
+func ConnReceiveInterceptor(i ReceiveInterceptor) ConnOption {
+	return func(c *conn) error {
+		if i == nil {
+			return errorNew("receive interceptor must not be nil")
+		}
+		c.receiveInterceptors = append(c.receiveInterceptors, i)
+		return nil
+	}
+}
+
+// SessionSendInterceptor adds an interceptor to every Sender
+// opened on the session.
+//
+// It runs after those added with ConnSendInterceptor.
+func SessionSendInterceptor(i SendInterceptor) SessionOption {
+	return func(s *Session) error {
+		if i == nil {
+			return errorNew("send interceptor must not be nil")
+		}
+		s.sendInterceptors = append(s.sendInterceptors, i)
+		return nil
+	}
+}
+
+// SessionReceiveInterceptor adds an interceptor to every Receiver
+// opened on the session.
+//
+// It runs after those added with ConnReceiveInterceptor.
+func SessionReceiveInterceptor(i ReceiveInterceptor) SessionOption {
+	return func(s *Session) error {
+		if i == nil {
+			return errorNew("receive interceptor must not be nil")
+		}
+		s.receiveInterceptors = append(s.receiveInterceptors, i)
+		return nil
+	}
+}
+
+// LinkSendInterceptor adds an interceptor to Sender.Send.
+//
+// It runs after those added with ConnSendInterceptor
+// and SessionSendInterceptor.
+func LinkSendInterceptor(i SendInterceptor) LinkOption {
+	return func(l *link) error {
+		if l.receiver != nil {
+			return errorNew("LinkSendInterceptor is not valid for Receiver")
+		}
+		if i == nil {
+			return errorNew("send interceptor must not be nil")
+		}
+		l.sendInterceptors = append(l.sendInterceptors, i)
+		return nil
+	}
+}
+
+// LinkReceiveInterceptor adds an interceptor to Receiver.Receive.
+//
+// It runs after those added with ConnReceiveInterceptor
+// and SessionReceiveInterceptor.
+func LinkReceiveInterceptor(i ReceiveInterceptor) LinkOption {
+	return func(l *link) error {
+		if l.receiver == nil {
+			return errorNew("LinkReceiveInterceptor is not valid for Sender")
+		}
+		if i == nil {
+			return errorNew("receive interceptor must not be nil")
+		}
+		l.receiveInterceptors = append(l.receiveInterceptors, i)
+		return nil
+	}
+}
+
+// sendInterceptorChain returns the conn's, session's and link's
+// send interceptors, outermost first.
+func (s *Session) sendInterceptorChain(link []SendInterceptor) []SendInterceptor {
+	var chain []SendInterceptor
+	chain = append(chain, s.conn.sendInterceptors...)
+	chain = append(chain, s.sendInterceptors...)
+	return append(chain, link...)
+}
+
+// receiveInterceptorChain returns the conn's, session's and link's
+// receive interceptors, outermost first.
+func (s *Session) receiveInterceptorChain(link []ReceiveInterceptor) []ReceiveInterceptor {
+	var chain []ReceiveInterceptor
+	chain = append(chain, s.conn.receiveInterceptors...)
+	chain = append(chain, s.receiveInterceptors...)
+	return append(chain, link...)
+}
+
+// chainSendInterceptors returns an invoker calling interceptors in
+// order, followed by final.
+func chainSendInterceptors(interceptors []SendInterceptor, final SendInvoker) SendInvoker {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, innerNext := interceptors[i], next
+		next = func(ctx context.Context, msg *Message) error {
+			return interceptor(ctx, msg, innerNext)
+		}
+	}
+	return next
+}
+
+// chainReceiveInterceptors returns an invoker calling interceptors in
+// order, followed by final.
+func chainReceiveInterceptors(interceptors []ReceiveInterceptor, final ReceiveInvoker) ReceiveInvoker {
+	next := final
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, innerNext := interceptors[i], next
+		next = func(ctx context.Context) (*Message, error) {
+			return interceptor(ctx, innerNext)
+		}
+	}
+	return next
+}
@@ -0,0 +1,70 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	var calls []string
+	sendInterceptor := func(name string) SendInterceptor {
+		return func(ctx context.Context, msg *Message, next SendInvoker) error {
+			calls = append(calls, name)
+			return next(ctx, msg)
+		}
+	}
+
+	c, err := newConn(nil, ConnSendInterceptor(sendInterceptor("conn")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSession(c, 0)
+	if err := SessionSendInterceptor(sendInterceptor("session"))(s); err != nil {
+		t.Fatal(err)
+	}
+	l, err := newLink(s, nil, []LinkOption{LinkSendInterceptor(sendInterceptor("link"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := chainSendInterceptors(s.sendInterceptorChain(l.sendInterceptors), func(ctx context.Context, msg *Message) error {
+		calls = append(calls, "send")
+		return nil
+	})
+	if err := send(context.Background(), NewMessage(nil)); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"conn", "session", "link", "send"}; !testEqual(calls, want) {
+		t.Errorf("send interceptor calls don't match expected:\n %s", testDiff(calls, want))
+	}
+
+	if _, err := newLink(s, &Receiver{}, []LinkOption{LinkSendInterceptor(sendInterceptor("link"))}); err == nil {
+		t.Error("expected error adding send interceptor to Receiver")
+	}
+}
+
+func TestReceiveInterceptor(t *testing.T) {
+	r := &Receiver{link: &link{
+		messages: make(chan Message, 1),
+		done:     make(chan struct{}),
+	}}
+	r.link.messages <- Message{Value: "plain"}
+
+	decrypt := func(ctx context.Context, next ReceiveInvoker) (*Message, error) {
+		msg, err := next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		msg.Value = "decrypted " + msg.Value.(string)
+		return msg, nil
+	}
+	r.invoker = chainReceiveInterceptors([]ReceiveInterceptor{decrypt}, r.receive)
+
+	msg, err := r.Receive(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Value != "decrypted plain" {
+		t.Errorf("Receive() value = %v, want %q", msg.Value, "decrypted plain")
+	}
+}
@@ -2,6 +2,8 @@
 
 package amqp
 
-// dummy functions used when debugging is not enabled
-
-func debug(_ int, _ string, _ ...interface{}) {}
+// defaultLogger returns the Logger used when none is set with
+// ConnLogger.
+func defaultLogger() Logger {
+	return nopLogger{}
+}
@@ -2,9 +2,11 @@
 
 package amqp
 
-import "log"
-import "os"
-import "strconv"
+import (
+	"log"
+	"os"
+	"strconv"
+)
 
 var (
 	debugLevel = 1
@@ -20,8 +22,17 @@ func init() {
 	debugLevel = level
 }
 
-func debug(level int, format string, v ...interface{}) {
-	if level <= debugLevel {
-		logger.Printf(format, v...)
+// defaultLogger returns the Logger used when none is set with
+// ConnLogger, writing to stderr.
+//
+// DEBUG_LEVEL 1 enables LogDebug, 2 and above also enables LogTrace.
+func defaultLogger() Logger {
+	level := LogInfo
+	switch {
+	case debugLevel >= 2:
+		level = LogTrace
+	case debugLevel == 1:
+		level = LogDebug
 	}
+	return StdLogger(logger, level)
 }
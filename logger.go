@@ -0,0 +1,136 @@
+package amqp
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+// Log levels, from most to least severe.
+const (
+	LogError LogLevel = iota // connection failures
+	LogWarn                  // unexpected frames and closing with an error
+	LogInfo                  // connection opened and closed
+	LogDebug                 // frames sent and received
+	LogTrace                 // frames as processed by each link, including transfers
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogError:
+		return "error"
+	case LogWarn:
+		return "warn"
+	case LogInfo:
+		return "info"
+	case LogDebug:
+		return "debug"
+	case LogTrace:
+		return "trace"
+	default:
+		return fmt.Sprintf("LogLevel(%d)", int(l))
+	}
+}
+
+// Logger is a leveled, structured logger.
+//
+// It can be adapted to logging libraries such as log/slog, zap or
+// logrus with a few lines of code.
+type Logger interface {
+	// Enabled reports whether entries of level are logged. Log is
+	// only called for enabled levels.
+	Enabled(level LogLevel) bool
+
+	// Log logs msg with the given fields, passed as alternating keys
+	// and values. Keys are strings.
+	//
+	// Connection entries have the fields "container_id" and, once
+	// connected, "remote_addr". Session entries add "channel", and
+	// link entries add "handle" and "link" with the link's name.
+	// Frames are logged in the field "frame" and errors in "error".
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// ConnLogger sets the Logger of the connection and its sessions
+// and links.
+//
+// Default: no logging, unless built with the debug tag, in which case
+// entries up to DEBUG_LEVEL are written to stderr.
+func ConnLogger(l Logger) ConnOption {
+	return func(c *conn) error {
+		if l == nil {
+			return errorNew("logger must not be nil")
+		}
+		c.logger = l
+		return nil
+	}
+}
+
+// StdLogger returns a Logger writing entries up to level to l,
+// formatted as the message followed by key=value fields.
+func StdLogger(l *log.Logger, level LogLevel) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level LogLevel
+}
+
+func (s *stdLogger) Enabled(level LogLevel) bool {
+	return level <= s.level
+}
+
+func (s *stdLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	var b strings.Builder
+	b.WriteString(strings.ToUpper(level.String()))
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var v interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			v = keyvals[i+1]
+		}
+		fmt.Fprintf(&b, " %v=%v", keyvals[i], v)
+	}
+	s.l.Print(b.String())
+}
+
+// nopLogger discards all entries.
+type nopLogger struct{}
+
+func (nopLogger) Enabled(LogLevel) bool                { return false }
+func (nopLogger) Log(LogLevel, string, ...interface{}) {}
+
+// log logs msg with the connection's fields followed by keyvals.
+func (c *conn) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if !c.logger.Enabled(level) {
+		return
+	}
+
+	fields := make([]interface{}, 0, 4+len(keyvals))
+	fields = append(fields, "container_id", c.containerID)
+	if c.net != nil {
+		fields = append(fields, "remote_addr", c.net.RemoteAddr())
+	}
+	c.logger.Log(level, msg, append(fields, keyvals...)...)
+}
+
+// log logs msg with the session's fields followed by keyvals.
+func (s *Session) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if !s.conn.logger.Enabled(level) {
+		return
+	}
+	s.conn.log(level, msg, append([]interface{}{"channel", s.channel}, keyvals...)...)
+}
+
+// log logs msg with the link's fields followed by keyvals.
+func (l *link) log(level LogLevel, msg string, keyvals ...interface{}) {
+	if !l.session.conn.logger.Enabled(level) {
+		return
+	}
+	l.session.log(level, msg, append([]interface{}{"handle", l.handle, "link", l.name}, keyvals...)...)
+}
@@ -0,0 +1,106 @@
+package amqp
+
+import (
+	"bytes"
+	"log"
+	"testing"
+)
+
+type logEntry struct {
+	level   LogLevel
+	msg     string
+	keyvals []interface{}
+}
+
+type recordingLogger struct {
+	level   LogLevel
+	entries []logEntry
+}
+
+func (r *recordingLogger) Enabled(level LogLevel) bool {
+	return level <= r.level
+}
+
+func (r *recordingLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	r.entries = append(r.entries, logEntry{level: level, msg: msg, keyvals: keyvals})
+}
+
+func TestConnLoggerUnexpectedFrame(t *testing.T) {
+	logger := &recordingLogger{level: LogWarn}
+	c, err := newConn(nil, ConnLogger(logger), ConnContainerID("container"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &link{name: "foo", handle: 3, session: newSession(c, 2)}
+
+	fr := &performEnd{}
+	if err := l.muxHandleFrame(fr); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []logEntry{{
+		level:   LogWarn,
+		msg:     "unexpected frame",
+		keyvals: []interface{}{"container_id", "container", "channel", uint16(2), "handle", uint32(3), "link", "foo", "frame", fr},
+	}}
+	if !testEqual(logger.entries, want) {
+		t.Errorf("log entries don't match expected:\n %s", testDiff(logger.entries, want))
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := StdLogger(log.New(&buf, "", 0), LogDebug)
+
+	if logger.Enabled(LogTrace) {
+		t.Error("LogTrace enabled, want disabled")
+	}
+	logger.Log(LogDebug, "TX", "channel", 1, "frame", "Flow{}", "odd")
+
+	const want = "DEBUG TX channel=1 frame=Flow{} odd=(MISSING)\n"
+	if got := buf.String(); got != want {
+		t.Errorf("StdLogger wrote %q, want %q", got, want)
+	}
+}
+
+func TestConnLoggerEvents(t *testing.T) {
+	peer, netConn := newTestPeer(t, func(body frameBody) []frameBody {
+		if _, ok := body.(*performOpen); ok {
+			return []frameBody{&performOpen{ContainerID: "peer"}}
+		}
+		return nil
+	})
+
+	logger := &recordingLogger{level: LogInfo}
+	client, err := New(netConn, ConnIdleTimeout(0), ConnLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	closeErr := &Error{Condition: ErrorInternalError, Description: "shutting down"}
+	if err := peer.send(&performClose{Error: closeErr}); err != nil {
+		t.Fatal(err)
+	}
+	// wait for the client's reply, then for mux to be done logging
+	peer.receiveClose()
+	client.Close()
+
+	type event struct {
+		level LogLevel
+		msg   string
+	}
+	var got []event
+	for _, e := range logger.entries {
+		got = append(got, event{e.level, e.msg})
+	}
+	want := []event{
+		{LogInfo, "connection opened"},
+		{LogWarn, "connection closed by peer"},
+	}
+	if !testEqual(got, want) {
+		t.Fatalf("log entries don't match expected:\n %s", testDiff(got, want))
+	}
+	if kv := logger.entries[1].keyvals; !testEqual(kv[len(kv)-1], closeErr) {
+		t.Errorf("logged error = %v, want %v", kv[len(kv)-1], closeErr)
+	}
+}
@@ -330,8 +330,9 @@ var (
 					value:      "bar value",
 				},
 			},
-			Outcomes:     []symbol{"amqp:accepted:list"},
-			Capabilities: []symbol{"barCap"},
+			DefaultOutcome: &stateReleased{},
+			Outcomes:       []symbol{"amqp:accepted:list"},
+			Capabilities:   []symbol{"barCap"},
 		},
 		&target{
 			Address:      "fooAddr",
@@ -0,0 +1,124 @@
+package amqp
+
+import (
+	"time"
+)
+
+// Metrics receives callbacks about the activity of a connection and
+// the sessions and links opened on it.
+//
+// Callbacks are made synchronously from the connection's goroutines and
+// must not block. Implementations should embed NopMetrics, so that
+// methods added to Metrics in the future have a default.
+type Metrics interface {
+	// ConnOpened is called when the connection has been established.
+	//
+	// The library does not reconnect. An application that reconnects
+	// after a failure opens a new Client, reported by ConnOpened.
+	ConnOpened()
+
+	// ConnClosed is called when the connection has closed. err is
+	// nil if it was closed by Client.Close.
+	ConnClosed(err error)
+
+	// FrameSent is called after a frame of size bytes is written.
+	// frameType is the name of the performative, e.g. "transfer",
+	// or "empty" for keepalives.
+	FrameSent(frameType string, size int)
+
+	// FrameReceived is called after a frame of size bytes is read.
+	FrameReceived(frameType string, size int)
+
+	// SessionWindowExhausted is called when a session stops sending
+	// transfers because its outgoing window, or the peer's incoming
+	// window, is exhausted.
+	SessionWindowExhausted()
+
+	// LinkCreditStalled is called when a link uses up the credit
+	// granted by the peer, blocking a Sender, or stopping delivery to
+	// a Receiver until buffered messages are read. It isn't called
+	// before the peer first grants credit. address is the link's target
+	// address for a Sender, and source address for a Receiver.
+	LinkCreditStalled(address string, sender bool)
+
+	// MessageSent is called when a message sent by Sender.Send is
+	// settled by the peer, with the time since Send was called.
+	// outcome is empty for messages sent pre-settled.
+	MessageSent(address string, latency time.Duration, outcome Outcome)
+
+	// DispositionSent is called when a Receiver settles count messages
+	// with outcome.
+	DispositionSent(address string, outcome Outcome, count int)
+}
+
+// NopMetrics is a Metrics ignoring every callback. It can be embedded
+// in a Metrics implementation to only handle some callbacks.
+type NopMetrics struct{}
+
+func (NopMetrics) ConnOpened()                                                  {}
+func (NopMetrics) ConnClosed(err error)                                         {}
+func (NopMetrics) FrameSent(frameType string, size int)                         {}
+func (NopMetrics) FrameReceived(frameType string, size int)                     {}
+func (NopMetrics) SessionWindowExhausted()                                      {}
+func (NopMetrics) LinkCreditStalled(address string, sender bool)                {}
+func (NopMetrics) MessageSent(address string, latency time.Duration, o Outcome) {}
+func (NopMetrics) DispositionSent(address string, outcome Outcome, count int)   {}
+
+// ConnMetrics sets the Metrics receiving callbacks about
+// the connection's activity.
+func ConnMetrics(m Metrics) ConnOption {
+	return func(c *conn) error {
+		if m == nil {
+			return errorNew("metrics must not be nil")
+		}
+		c.metrics = m
+		return nil
+	}
+}
+
+// frameType returns the name of the performative of body.
+func frameType(body frameBody) string {
+	switch body.(type) {
+	case *performOpen:
+		return "open"
+	case *performBegin:
+		return "begin"
+	case *performAttach:
+		return "attach"
+	case *performFlow:
+		return "flow"
+	case *performTransfer:
+		return "transfer"
+	case *performDisposition:
+		return "disposition"
+	case *performDetach:
+		return "detach"
+	case *performEnd:
+		return "end"
+	case *performClose:
+		return "close"
+	case *saslInit:
+		return "sasl-init"
+	case *saslMechanisms:
+		return "sasl-mechanisms"
+	case *saslOutcome:
+		return "sasl-outcome"
+	default:
+		return "unknown"
+	}
+}
+
+// address returns the target address of a sender link,
+// or the source address of a receiver link.
+func (l *link) address() string {
+	if l.receiver != nil {
+		if l.source == nil {
+			return ""
+		}
+		return l.source.Address
+	}
+	if l.target == nil {
+		return ""
+	}
+	return l.target.Address
+}
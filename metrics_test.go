@@ -0,0 +1,129 @@
+package amqp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	NopMetrics
+
+	mu           sync.Mutex
+	dispositions map[Outcome]int
+	stalls       chan string // addresses of stalled links, if not nil
+}
+
+func (m *recordingMetrics) LinkCreditStalled(address string, sender bool) {
+	if m.stalls != nil {
+		m.stalls <- address
+	}
+}
+
+func (m *recordingMetrics) DispositionSent(address string, outcome Outcome, count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dispositions[outcome] += count
+}
+
+func TestMetricsDispositionSent(t *testing.T) {
+	m := &recordingMetrics{dispositions: make(map[Outcome]int)}
+	c, _, stop := newTestConn(t, ConnMetrics(m))
+	defer stop()
+
+	r := &Receiver{link: &link{session: newSession(c, 0)}}
+	var msgs []*Message
+	for _, id := range []uint32{1, 2, 3, 7} {
+		msgs = append(msgs, &Message{receiver: r, deliveryID: id})
+	}
+
+	err := r.Settle(context.Background(), msgs, Disposition{Outcome: OutcomeReleased})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = msgs[0].AcceptContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	want := map[Outcome]int{OutcomeReleased: 4, OutcomeAccepted: 1}
+	if !testEqual(m.dispositions, want) {
+		t.Errorf("dispositions don't match expected:\n %s", testDiff(m.dispositions, want))
+	}
+}
+
+func TestFrameType(t *testing.T) {
+	tests := []struct {
+		body frameBody
+		want string
+	}{
+		{&performOpen{}, "open"},
+		{&performTransfer{}, "transfer"},
+		{&performClose{}, "close"},
+		{&saslOutcome{}, "sasl-outcome"},
+	}
+
+	for _, tt := range tests {
+		if got := frameType(tt.body); got != tt.want {
+			t.Errorf("frameType(%T) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestConnMetricsNil(t *testing.T) {
+	if _, err := newConn(nil, ConnMetrics(nil)); err == nil {
+		t.Error("newConn() with nil Metrics succeeded, want error")
+	}
+}
+
+func TestMetricsLinkCreditStalled(t *testing.T) {
+	m := &recordingMetrics{stalls: make(chan string, 10)}
+	c, _, stop := newTestConn(t, ConnMetrics(m))
+	defer stop()
+
+	s := newSession(c, 0)
+	l, err := newLink(s, nil, []LinkOption{LinkTargetAddress("queue")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.rx = make(chan frameBody, 1)
+	l.transfers = make(chan performTransfer)
+	go l.mux()
+	defer l.closeWithError(nil)
+
+	noStall := func(when string) {
+		t.Helper()
+		select {
+		case addr := <-m.stalls:
+			t.Fatalf("LinkCreditStalled(%q) called %s", addr, when)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+
+	// the link starts without credit
+	noStall("before credit was granted")
+
+	var (
+		deliveryCount uint32
+		credit        uint32 = 1
+	)
+	l.rx <- &performFlow{DeliveryCount: &deliveryCount, LinkCredit: &credit}
+	noStall("with credit")
+
+	// use up the credit
+	l.transfers <- performTransfer{}
+	<-s.txTransfer
+
+	select {
+	case addr := <-m.stalls:
+		if addr != "queue" {
+			t.Errorf("LinkCreditStalled(%q), want %q", addr, "queue")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LinkCreditStalled not called after the credit was used up")
+	}
+	noStall("twice")
+}
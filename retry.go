@@ -0,0 +1,221 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Default retry policy values
+const (
+	DefaultRetryMinBackoff = 100 * time.Millisecond
+	DefaultRetryMaxBackoff = 10 * time.Second
+)
+
+// retryableConditions are error conditions which indicate a
+// temporary problem on the peer.
+var retryableConditions = map[ErrorCondition]bool{
+	ErrorInternalError:         true,
+	ErrorResourceLimitExceeded: true,
+	ErrorResourceLocked:        true,
+	ErrorConnectionForced:      true,
+	ErrorDetachForced:          true,
+	ErrorTransferLimitExceeded: true,
+}
+
+// IsRetryable reports whether the operation that returned err may succeed
+// if retried, possibly after creating a new Client, Session or link.
+//
+// Errors from the network, such as an idle timeout or a reset connection,
+// are retryable, as are ErrorConditions indicating a temporary problem on
+// the peer, such as ErrorResourceLimitExceeded or ErrorConnectionForced.
+// Endpoints closed by the peer without an error are retryable.
+//
+// Endpoints closed locally and context errors are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	// closed locally or abandoned by the caller
+	switch {
+	case errors.Is(err, ErrConnClosed),
+		errors.Is(err, ErrSessionClosed),
+		errors.Is(err, ErrLinkClosed),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return false
+	}
+
+	// peer provided a reason
+	var amqpErr *Error
+	if errors.As(err, &amqpErr) {
+		return retryableConditions[amqpErr.Condition]
+	}
+
+	// peer closed without a reason
+	var (
+		connErr    *ConnectionError
+		sessionErr *SessionError
+		linkErr    *LinkError
+	)
+	switch {
+	case errors.As(err, &connErr) && connErr.Remote,
+		errors.As(err, &sessionErr) && sessionErr.Remote,
+		errors.As(err, &linkErr) && linkErr.Remote:
+		return true
+	}
+
+	// network errors
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, ErrTimeout):
+		return true
+	}
+
+	return false
+}
+
+// IsTransient reports whether the operation that returned err may succeed
+// if retried using the same Client, Session and link.
+//
+// An error is transient when it is retryable and the endpoint that
+// returned it has not been closed, e.g. a message rejected with
+// ErrorResourceLimitExceeded.
+func IsTransient(err error) bool {
+	if !IsRetryable(err) {
+		return false
+	}
+
+	var (
+		connErr    *ConnectionError
+		sessionErr *SessionError
+		linkErr    *LinkError
+	)
+	switch {
+	case errors.As(err, &connErr),
+		errors.As(err, &sessionErr),
+		errors.As(err, &linkErr),
+		errors.Is(err, ErrTimeout):
+		return false
+	}
+
+	// the endpoint is closed on any network error
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return false
+	}
+
+	return true
+}
+
+// RetryPolicy configures the retrying of failed operations.
+//
+// Delays between attempts grow exponentially from MinBackoff to MaxBackoff,
+// randomized by Jitter.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of retries after the
+	// first attempt. Zero disables retries.
+	MaxRetries int
+
+	// MinBackoff is the delay before the first retry.
+	//
+	// Default: DefaultRetryMinBackoff.
+	MinBackoff time.Duration
+
+	// MaxBackoff is the maximum delay between retries.
+	//
+	// Default: DefaultRetryMaxBackoff.
+	MaxBackoff time.Duration
+
+	// Jitter is the fraction, between 0 and 1, by which each delay
+	// is randomly reduced. This prevents clients that failed at the
+	// same time from retrying at the same time.
+	Jitter float64
+
+	// ShouldRetry overrides the classification of errors. If nil,
+	// IsTransient is used when sending and IsRetryable when attaching.
+	//
+	// Errors are not retried once the link or session used for the
+	// operation is closed, regardless of ShouldRetry.
+	ShouldRetry func(error) bool
+}
+
+func (p *RetryPolicy) validate() error {
+	switch {
+	case p.MaxRetries < 0:
+		return errorNew("retry policy MaxRetries cannot be negative")
+	case p.MinBackoff < 0 || p.MaxBackoff < 0:
+		return errorNew("retry policy backoff cannot be negative")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return errorNew("retry policy Jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (p *RetryPolicy) backoff(retry int) time.Duration {
+	var (
+		min = p.MinBackoff
+		max = p.MaxBackoff
+	)
+	if min == 0 {
+		min = DefaultRetryMinBackoff
+	}
+	if max == 0 {
+		max = DefaultRetryMaxBackoff
+	}
+
+	d := min
+	for i := 1; i < retry && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// run calls fn until it succeeds, returns an error that shouldn't
+// be retried, MaxRetries is reached, or ctx or done complete.
+//
+// retryable classifies errors when p.ShouldRetry is nil.
+func (p *RetryPolicy) run(ctx context.Context, done <-chan struct{}, retryable func(error) bool, fn func() error) error {
+	if p.ShouldRetry != nil {
+		retryable = p.ShouldRetry
+	}
+
+	err := fn()
+	for retry := 1; err != nil && retry <= p.MaxRetries && retryable(err); retry++ {
+		// the link or session is closed, retrying can't succeed
+		select {
+		case <-done:
+			return err
+		default:
+		}
+
+		timer := time.NewTimer(p.backoff(retry))
+		select {
+		case <-timer.C:
+		case <-done:
+			timer.Stop()
+			return err
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+
+		err = fn()
+	}
+	return err
+}
@@ -0,0 +1,120 @@
+package amqp
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetryClassification(t *testing.T) {
+	tests := []struct {
+		label     string
+		err       error
+		retryable bool
+		transient bool
+	}{
+		{label: "nil"},
+		{label: "conn-closed", err: &ConnectionError{inner: ErrConnClosed}},
+		{label: "link-closed", err: &LinkError{inner: ErrLinkClosed}},
+		{label: "canceled", err: context.Canceled},
+		{
+			label:     "rejected-resource-limit",
+			err:       &Error{Condition: ErrorResourceLimitExceeded},
+			retryable: true,
+			transient: true,
+		},
+		{label: "rejected-not-found", err: &Error{Condition: ErrorNotFound}},
+		{
+			label:     "connection-forced",
+			err:       &ConnectionError{RemoteError: &Error{Condition: ErrorConnectionForced}, Remote: true},
+			retryable: true,
+		},
+		{
+			label: "detach-unauthorized",
+			err:   &LinkError{RemoteError: &Error{Condition: ErrorUnauthorizedAccess}, Remote: true},
+		},
+		{label: "remote-end-no-error", err: &SessionError{Remote: true}, retryable: true},
+		{label: "eof", err: &ConnectionError{inner: io.EOF}, retryable: true},
+		{
+			label:     "net-timeout",
+			err:       &ConnectionError{inner: &net.OpError{Op: "read", Err: errTimeoutNet{}}},
+			retryable: true,
+		},
+		{label: "timeout", err: ErrTimeout, retryable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.retryable {
+				t.Errorf("IsRetryable() = %t, want %t", got, tt.retryable)
+			}
+			if got := IsTransient(tt.err); got != tt.transient {
+				t.Errorf("IsTransient() = %t, want %t", got, tt.transient)
+			}
+		})
+	}
+}
+
+type errTimeoutNet struct{}
+
+func (errTimeoutNet) Error() string   { return "i/o timeout" }
+func (errTimeoutNet) Timeout() bool   { return true }
+func (errTimeoutNet) Temporary() bool { return true }
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for retry, want := range []time.Duration{10, 20, 40, 50, 50} {
+		if got := p.backoff(retry + 1); got != want*time.Millisecond {
+			t.Errorf("backoff(%d) = %v, want %v", retry+1, got, want*time.Millisecond)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(1); got < 5*time.Millisecond || got > 10*time.Millisecond {
+			t.Fatalf("backoff(1) with jitter = %v, want between 5ms and 10ms", got)
+		}
+	}
+}
+
+func TestRetryPolicyRun(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 2, MinBackoff: time.Millisecond}
+	transientErr := &Error{Condition: ErrorResourceLimitExceeded}
+
+	var calls int
+	err := p.run(context.Background(), nil, IsTransient, func() error {
+		calls++
+		return transientErr
+	})
+	if err != transientErr || calls != 3 {
+		t.Errorf("run() = %v after %d calls, want %v after 3 calls", err, calls, transientErr)
+	}
+
+	calls = 0
+	err = p.run(context.Background(), nil, IsTransient, func() error {
+		calls++
+		return &Error{Condition: ErrorNotFound}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("run() = %v after %d calls, want error after 1 call", err, calls)
+	}
+
+	// a closed link is not retried, even if ShouldRetry accepts the error
+	done := make(chan struct{})
+	close(done)
+	p.ShouldRetry = func(error) bool { return true }
+	calls = 0
+	err = p.run(context.Background(), done, IsTransient, func() error {
+		calls++
+		return &LinkError{}
+	})
+	if err == nil || calls != 1 {
+		t.Errorf("run() = %v after %d calls on a closed link, want error after 1 call", err, calls)
+	}
+
+	if err := (&RetryPolicy{Jitter: 2}).validate(); err == nil {
+		t.Error("validate() accepted Jitter > 1")
+	}
+}
@@ -0,0 +1,172 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Router is a Handler that dispatches each message to the Handler
+// registered for its subject, address or application properties.
+//
+// A message is matched, in order, by:
+//  1. MessageProperties.Subject, registered with HandleSubject.
+//  2. MessageProperties.To, registered with HandleTo.
+//  3. ApplicationProperties, registered with HandleProperty. Property
+//     routes are tried in the order they were registered.
+//
+// Messages that don't match are rejected, or settled as configured
+// with RouterUnmatched.
+//
+// A Router may be used with any number of Receivers, via Receiver.Handle.
+// It is safe to register handlers concurrently with handling messages.
+// The zero value is ready to use.
+type Router struct {
+	unmatched Disposition // outcome of messages without a matching handler, rejected if empty
+
+	mu         sync.RWMutex
+	subjects   map[string]Handler
+	addresses  map[string]Handler
+	properties []propertyRoute
+}
+
+// RouterOption is a function for configuring a Router.
+type RouterOption func(*Router) error
+
+// NewRouter returns a Router configured with opts.
+func NewRouter(opts ...RouterOption) (*Router, error) {
+	rt := new(Router)
+	for _, opt := range opts {
+		err := opt(rt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
+// RouterUnmatched sets the disposition of messages without a
+// matching handler.
+//
+// Default: OutcomeRejected.
+func RouterUnmatched(d Disposition) RouterOption {
+	return func(rt *Router) error {
+		_, err := d.state()
+		if err != nil {
+			return err
+		}
+		rt.unmatched = d
+		return nil
+	}
+}
+
+type propertyRoute struct {
+	key     string
+	value   interface{}
+	handler Handler
+}
+
+// HandleSubject registers the handler for messages with the
+// given MessageProperties.Subject.
+//
+// HandleSubject panics if handler is nil or a handler is already
+// registered for subject.
+func (rt *Router) HandleSubject(subject string, handler Handler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.subjects == nil {
+		rt.subjects = make(map[string]Handler)
+	}
+	registerRoute(rt.subjects, "subject", subject, handler)
+}
+
+// HandleTo registers the handler for messages with the
+// given MessageProperties.To address.
+//
+// HandleTo panics if handler is nil or a handler is already
+// registered for address.
+func (rt *Router) HandleTo(address string, handler Handler) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if rt.addresses == nil {
+		rt.addresses = make(map[string]Handler)
+	}
+	registerRoute(rt.addresses, "address", address, handler)
+}
+
+// HandleProperty registers the handler for messages whose
+// ApplicationProperties contain key with the given value.
+//
+// Values are compared with ==, so value must have the same type as
+// the decoded property, e.g. int64 rather than int for a long.
+//
+// HandleProperty panics if handler is nil, value is not comparable
+// or a handler is already registered for key and value.
+func (rt *Router) HandleProperty(key string, value interface{}, handler Handler) {
+	if handler == nil {
+		panic("amqp: nil handler")
+	}
+	if value != nil && !reflect.TypeOf(value).Comparable() {
+		panic(fmt.Sprintf("amqp: property %q value of type %T is not comparable", key, value))
+	}
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	for _, route := range rt.properties {
+		if route.key == key && route.value == value {
+			panic(fmt.Sprintf("amqp: multiple handlers for property %q value %v", key, value))
+		}
+	}
+	rt.properties = append(rt.properties, propertyRoute{key: key, value: value, handler: handler})
+}
+
+func registerRoute(routes map[string]Handler, kind, pattern string, handler Handler) {
+	if handler == nil {
+		panic("amqp: nil handler")
+	}
+	if _, ok := routes[pattern]; ok {
+		panic(fmt.Sprintf("amqp: multiple handlers for %s %q", kind, pattern))
+	}
+	routes[pattern] = handler
+}
+
+// Handler returns the handler for msg, or nil if there is none.
+func (rt *Router) Handler(msg *Message) Handler {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	if p := msg.Properties; p != nil {
+		if h, ok := rt.subjects[p.Subject]; ok && p.Subject != "" {
+			return h
+		}
+		if h, ok := rt.addresses[p.To]; ok && p.To != "" {
+			return h
+		}
+	}
+
+	for _, route := range rt.properties {
+		v, ok := msg.ApplicationProperties[route.key]
+		if ok && v == route.value {
+			return route.handler
+		}
+	}
+	return nil
+}
+
+// HandleMessage dispatches msg to the matching handler. If there is
+// none, a *DispositionError with the unmatched disposition is returned.
+func (rt *Router) HandleMessage(ctx context.Context, msg *Message) error {
+	if h := rt.Handler(msg); h != nil {
+		return h.HandleMessage(ctx, msg)
+	}
+
+	d := rt.unmatched
+	if d.Outcome == "" {
+		d.Outcome = OutcomeRejected
+	}
+	return &DispositionError{Disposition: d}
+}
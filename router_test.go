@@ -0,0 +1,110 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	var (
+		rt  Router
+		got string
+	)
+	route := func(name string) Handler {
+		return HandlerFunc(func(ctx context.Context, msg *Message) error {
+			got = name
+			return nil
+		})
+	}
+	rt.HandleSubject("orders", route("subject"))
+	rt.HandleTo("/queues/audit", route("to"))
+	rt.HandleProperty("type", "invoice", route("property"))
+	rt.HandleProperty("priority", int64(1), route("priority"))
+
+	tests := []struct {
+		label string
+		msg   *Message
+		want  string
+	}{
+		{
+			label: "subject-before-to",
+			msg:   &Message{Properties: &MessageProperties{Subject: "orders", To: "/queues/audit"}},
+			want:  "subject",
+		},
+		{
+			label: "to",
+			msg:   &Message{Properties: &MessageProperties{Subject: "other", To: "/queues/audit"}},
+			want:  "to",
+		},
+		{
+			label: "property",
+			msg:   &Message{ApplicationProperties: map[string]interface{}{"type": "invoice"}},
+			want:  "property",
+		},
+		{
+			label: "property-typed",
+			msg:   &Message{ApplicationProperties: map[string]interface{}{"priority": int64(1)}},
+			want:  "priority",
+		},
+		{
+			label: "property-type-mismatch",
+			msg:   &Message{ApplicationProperties: map[string]interface{}{"priority": int32(1)}},
+		},
+		{
+			label: "unmatched",
+			msg:   &Message{Properties: &MessageProperties{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			got = ""
+			err := rt.HandleMessage(context.Background(), tt.msg)
+
+			if tt.want == "" {
+				var dispErr *DispositionError
+				if !errors.As(err, &dispErr) || dispErr.Disposition.Outcome != OutcomeRejected {
+					t.Fatalf("HandleMessage() error = %v, want rejected *DispositionError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("routed to %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+}
+
+func TestRouterUnmatched(t *testing.T) {
+	rt, err := NewRouter(RouterUnmatched(Disposition{Outcome: OutcomeReleased}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dispErr *DispositionError
+	err = rt.HandleMessage(context.Background(), &Message{})
+	if !errors.As(err, &dispErr) || dispErr.Disposition.Outcome != OutcomeReleased {
+		t.Errorf("HandleMessage() error = %v, want released *DispositionError", err)
+	}
+
+	if _, err := NewRouter(RouterUnmatched(Disposition{Outcome: "bogus"})); err == nil {
+		t.Error("NewRouter() accepted an invalid unmatched disposition")
+	}
+}
+
+func TestRouterDuplicatePanics(t *testing.T) {
+	var rt Router
+	h := HandlerFunc(func(ctx context.Context, msg *Message) error { return nil })
+	rt.HandleSubject("orders", h)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic registering duplicate subject")
+		}
+	}()
+	rt.HandleSubject("orders", h)
+}
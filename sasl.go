@@ -1,5 +1,7 @@
 package amqp
 
+import "fmt"
+
 // SASL Codes
 const (
 	codeSASLOK      saslCode = iota // Connection authentication succeeded.
@@ -17,6 +19,23 @@ const (
 
 type saslCode uint8
 
+func (s saslCode) String() string {
+	switch s {
+	case codeSASLOK:
+		return "ok"
+	case codeSASLAuth:
+		return "auth"
+	case codeSASLSys:
+		return "sys"
+	case codeSASLSysPerm:
+		return "sys-perm"
+	case codeSASLSysTemp:
+		return "sys-temp"
+	default:
+		return fmt.Sprintf("saslCode(%d)", uint8(s))
+	}
+}
+
 func (s saslCode) marshal(wr *buffer) error {
 	return marshal(wr, uint8(s))
 }
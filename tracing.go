@@ -0,0 +1,316 @@
+package amqp
+
+import (
+	"context"
+	"sort"
+)
+
+// TraceCarrier stores trace context in a message.
+//
+// It has the same method set as the TextMapCarrier of OpenTelemetry,
+// so a TraceCarrier can be passed directly to an OpenTelemetry
+// propagator.
+type TraceCarrier interface {
+	Get(key string) string
+	Set(key, value string)
+	Keys() []string
+}
+
+// Propagator injects trace context from a context.Context into a
+// message, and extracts it from a received message.
+//
+// An OpenTelemetry TextMapPropagator can be adapted with a type
+// forwarding both methods, as TraceCarrier satisfies its carrier:
+//
+//	type otelPropagator struct{ propagation.TextMapPropagator }
+//
+//	func (p otelPropagator) Inject(ctx context.Context, c amqp.TraceCarrier) {
+//		p.TextMapPropagator.Inject(ctx, c)
+//	}
+//
+//	func (p otelPropagator) Extract(ctx context.Context, c amqp.TraceCarrier) context.Context {
+//		return p.TextMapPropagator.Extract(ctx, c)
+//	}
+type Propagator interface {
+	Inject(ctx context.Context, carrier TraceCarrier)
+	Extract(ctx context.Context, carrier TraceCarrier) context.Context
+}
+
+// ConnTracePropagator sets the Propagator used by every Sender and
+// Receiver opened on the connection, and the carrier storing trace
+// context in messages, such as MessageCarrier or AnnotationsCarrier.
+// A nil carrier uses MessageCarrier.
+//
+// Sender.Send injects the trace context of its ctx into the message,
+// after any send interceptors have run. Receiver.Handle extracts the
+// trace context of each message into the ctx passed to the handler.
+// For messages returned by Receiver.Receive, use
+// Message.ExtractTraceContext.
+func ConnTracePropagator(p Propagator, carrier func(*Message) TraceCarrier) ConnOption {
+	return func(c *conn) error {
+		c.propagator = p
+		c.traceCarrier = carrier
+		return nil
+	}
+}
+
+// LinkTracePropagator sets the Propagator and carrier of the link,
+// overriding those set with ConnTracePropagator. A nil carrier uses
+// MessageCarrier.
+func LinkTracePropagator(p Propagator, carrier func(*Message) TraceCarrier) LinkOption {
+	return func(l *link) error {
+		l.propagator = p
+		l.traceCarrier = carrier
+		return nil
+	}
+}
+
+// tracePropagator returns the link's Propagator and carrier, or the
+// connection's if the link has no Propagator.
+func (l *link) tracePropagator() (Propagator, func(*Message) TraceCarrier) {
+	p, carrier := l.propagator, l.traceCarrier
+	if p == nil {
+		p, carrier = l.session.conn.propagator, l.session.conn.traceCarrier
+	}
+	if carrier == nil {
+		carrier = MessageCarrier
+	}
+	return p, carrier
+}
+
+// injectInterceptor returns a SendInterceptor injecting
+// trace context with p into carrier.
+func injectInterceptor(p Propagator, carrier func(*Message) TraceCarrier) SendInterceptor {
+	return func(ctx context.Context, msg *Message, next SendInvoker) error {
+		p.Inject(ctx, carrier(msg))
+		return next(ctx, msg)
+	}
+}
+
+// ExtractTraceContext returns a copy of ctx carrying the trace context
+// of a received message, extracted with the Propagator of its Receiver.
+// If the Receiver has no Propagator, ctx is returned.
+func (m *Message) ExtractTraceContext(ctx context.Context) context.Context {
+	if m.receiver == nil || m.receiver.propagator == nil {
+		return ctx
+	}
+	return m.receiver.propagator.Extract(ctx, m.receiver.traceCarrier(m))
+}
+
+// MessageCarrier returns a TraceCarrier storing trace context in
+// the application properties of msg.
+func MessageCarrier(msg *Message) TraceCarrier {
+	return applicationPropertiesCarrier{msg: msg}
+}
+
+// AnnotationsCarrier returns a TraceCarrier storing trace context in
+// the message annotations of msg.
+func AnnotationsCarrier(msg *Message) TraceCarrier {
+	return annotationsCarrier{msg: msg}
+}
+
+type applicationPropertiesCarrier struct {
+	msg *Message
+}
+
+func (c applicationPropertiesCarrier) Get(key string) string {
+	v, _ := c.msg.ApplicationProperties[key].(string)
+	return v
+}
+
+func (c applicationPropertiesCarrier) Set(key, value string) {
+	if c.msg.ApplicationProperties == nil {
+		c.msg.ApplicationProperties = make(map[string]interface{})
+	}
+	c.msg.ApplicationProperties[key] = value
+}
+
+func (c applicationPropertiesCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.ApplicationProperties))
+	for k, v := range c.msg.ApplicationProperties {
+		if _, ok := v.(string); ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// annotationsCarrier sends keys as symbols, as required for message
+// annotations. Received symbols are decoded as strings.
+type annotationsCarrier struct {
+	msg *Message
+}
+
+func (c annotationsCarrier) Get(key string) string {
+	v, ok := c.msg.Annotations[key]
+	if !ok {
+		v = c.msg.Annotations[symbol(key)]
+	}
+	s, _ := v.(string)
+	return s
+}
+
+func (c annotationsCarrier) Set(key, value string) {
+	if c.msg.Annotations == nil {
+		c.msg.Annotations = make(Annotations)
+	}
+	delete(c.msg.Annotations, key)
+	c.msg.Annotations[symbol(key)] = value
+}
+
+func (c annotationsCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Annotations))
+	for k, v := range c.msg.Annotations {
+		if _, ok := v.(string); !ok {
+			continue
+		}
+		switch k := k.(type) {
+		case string:
+			keys = append(keys, k)
+		case symbol:
+			keys = append(keys, string(k))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// TraceContext is a W3C Trace Context.
+//
+// It is used by TraceContextPropagator and DiagnosticIDPropagator
+// when no tracing SDK is in use.
+type TraceContext struct {
+	TraceParent string // e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	TraceState  string // optional vendor-specific trace data
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext returns a copy of ctx carrying tc.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the TraceContext carried by ctx.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// W3C Trace Context and Diagnostic-Id property names.
+const (
+	traceParentKey  = "traceparent"
+	traceStateKey   = "tracestate"
+	diagnosticIDKey = "Diagnostic-Id"
+)
+
+// TraceContextPropagator propagates a TraceContext using the W3C
+// traceparent and tracestate properties.
+type TraceContextPropagator struct{}
+
+// Inject sets traceparent and tracestate from the TraceContext of ctx.
+func (TraceContextPropagator) Inject(ctx context.Context, carrier TraceCarrier) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok || !validTraceParent(tc.TraceParent) {
+		return
+	}
+	carrier.Set(traceParentKey, tc.TraceParent)
+	if tc.TraceState != "" {
+		carrier.Set(traceStateKey, tc.TraceState)
+	}
+}
+
+// Extract returns a copy of ctx carrying the TraceContext in
+// traceparent and tracestate. If traceparent is missing or
+// invalid, ctx is returned.
+func (TraceContextPropagator) Extract(ctx context.Context, carrier TraceCarrier) context.Context {
+	tp := carrier.Get(traceParentKey)
+	if !validTraceParent(tp) {
+		return ctx
+	}
+	return ContextWithTraceContext(ctx, TraceContext{
+		TraceParent: tp,
+		TraceState:  carrier.Get(traceStateKey),
+	})
+}
+
+// DiagnosticIDPropagator propagates the traceparent of a TraceContext
+// in the Diagnostic-Id property, as used by Azure Service Bus and
+// Event Hubs.
+type DiagnosticIDPropagator struct{}
+
+// Inject sets Diagnostic-Id from the TraceContext of ctx.
+func (DiagnosticIDPropagator) Inject(ctx context.Context, carrier TraceCarrier) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok || !validTraceParent(tc.TraceParent) {
+		return
+	}
+	carrier.Set(diagnosticIDKey, tc.TraceParent)
+}
+
+// Extract returns a copy of ctx carrying the TraceContext in
+// Diagnostic-Id. If Diagnostic-Id is missing or not in W3C format,
+// ctx is returned.
+//
+// When combined with TraceContextPropagator, place DiagnosticIDPropagator
+// first so that traceparent and tracestate take precedence.
+func (DiagnosticIDPropagator) Extract(ctx context.Context, carrier TraceCarrier) context.Context {
+	id := carrier.Get(diagnosticIDKey)
+	if !validTraceParent(id) {
+		return ctx
+	}
+	return ContextWithTraceContext(ctx, TraceContext{TraceParent: id})
+}
+
+// CompositePropagator returns a Propagator calling each of
+// propagators in order.
+func CompositePropagator(propagators ...Propagator) Propagator {
+	return compositePropagator(propagators)
+}
+
+type compositePropagator []Propagator
+
+func (c compositePropagator) Inject(ctx context.Context, carrier TraceCarrier) {
+	for _, p := range c {
+		p.Inject(ctx, carrier)
+	}
+}
+
+func (c compositePropagator) Extract(ctx context.Context, carrier TraceCarrier) context.Context {
+	for _, p := range c {
+		ctx = p.Extract(ctx, carrier)
+	}
+	return ctx
+}
+
+// validTraceParent reports whether tp is a W3C traceparent:
+// version-traceid-parentid-flags in lowercase hex, with non-zero
+// trace and parent IDs.
+func validTraceParent(tp string) bool {
+	// 2 + 1 + 32 + 1 + 16 + 1 + 2
+	if len(tp) != 55 || tp[2] != '-' || tp[35] != '-' || tp[52] != '-' {
+		return false
+	}
+	if tp[:2] == "ff" {
+		return false
+	}
+
+	var traceIDZero, parentIDZero = true, true
+	for i := 0; i < len(tp); i++ {
+		if i == 2 || i == 35 || i == 52 {
+			continue
+		}
+		ch := tp[i]
+		if !('0' <= ch && ch <= '9' || 'a' <= ch && ch <= 'f') {
+			return false
+		}
+		switch {
+		case i > 2 && i < 35 && ch != '0':
+			traceIDZero = false
+		case i > 35 && i < 52 && ch != '0':
+			parentIDZero = false
+		}
+	}
+	return !traceIDZero && !parentIDZero
+}
@@ -0,0 +1,160 @@
+package amqp
+
+import (
+	"context"
+	"testing"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTracePropagation(t *testing.T) {
+	p := CompositePropagator(DiagnosticIDPropagator{}, TraceContextPropagator{})
+	tc := TraceContext{TraceParent: testTraceParent, TraceState: "vendor=value"}
+
+	msg := NewMessage([]byte("hello"))
+	p.Inject(ContextWithTraceContext(context.Background(), tc), MessageCarrier(msg))
+
+	want := map[string]interface{}{
+		"traceparent":   testTraceParent,
+		"tracestate":    "vendor=value",
+		"Diagnostic-Id": testTraceParent,
+	}
+	if !testEqual(msg.ApplicationProperties, want) {
+		t.Errorf("injected properties don't match expected:\n %s", testDiff(msg.ApplicationProperties, want))
+	}
+
+	got, ok := TraceContextFromContext(p.Extract(context.Background(), MessageCarrier(msg)))
+	if !ok || got != tc {
+		t.Errorf("extracted %+v, want %+v", got, tc)
+	}
+
+	// Diagnostic-Id alone
+	msg = &Message{ApplicationProperties: map[string]interface{}{"Diagnostic-Id": testTraceParent}}
+	got, ok = TraceContextFromContext(p.Extract(context.Background(), MessageCarrier(msg)))
+	if !ok || got.TraceParent != testTraceParent {
+		t.Errorf("extracted %+v from Diagnostic-Id, want traceparent %s", got, testTraceParent)
+	}
+}
+
+func TestAnnotationsCarrier(t *testing.T) {
+	msg := &Message{Annotations: Annotations{"x-opt-partition-key": "p1", "x-opt-offset": int64(7)}}
+	c := AnnotationsCarrier(msg)
+	c.Set("traceparent", testTraceParent)
+
+	if _, ok := msg.Annotations[symbol("traceparent")]; !ok {
+		t.Error("annotation key not set as symbol")
+	}
+	if got := c.Get("traceparent"); got != testTraceParent {
+		t.Errorf("Get() = %q, want %q", got, testTraceParent)
+	}
+	if want := []string{"traceparent", "x-opt-partition-key"}; !testEqual(c.Keys(), want) {
+		t.Errorf("Keys() don't match expected:\n %s", testDiff(c.Keys(), want))
+	}
+}
+
+func TestTracePropagatorCarrier(t *testing.T) {
+	tc := TraceContext{TraceParent: testTraceParent}
+	ctx := ContextWithTraceContext(context.Background(), tc)
+
+	tests := []struct {
+		label    string
+		connOpts []ConnOption
+		linkOpts []LinkOption
+
+		wantProperties  map[string]interface{}
+		wantAnnotations Annotations
+	}{
+		{
+			label:    "conn default carrier",
+			connOpts: []ConnOption{ConnTracePropagator(TraceContextPropagator{}, nil)},
+
+			wantProperties: map[string]interface{}{"traceparent": testTraceParent},
+		},
+		{
+			label:    "conn annotations",
+			connOpts: []ConnOption{ConnTracePropagator(TraceContextPropagator{}, AnnotationsCarrier)},
+
+			wantAnnotations: Annotations{symbol("traceparent"): testTraceParent},
+		},
+		{
+			label:    "link overrides conn",
+			connOpts: []ConnOption{ConnTracePropagator(TraceContextPropagator{}, AnnotationsCarrier)},
+			linkOpts: []LinkOption{LinkTracePropagator(DiagnosticIDPropagator{}, MessageCarrier)},
+
+			wantProperties: map[string]interface{}{"Diagnostic-Id": testTraceParent},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			c, err := newConn(nil, tt.connOpts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := newSession(c, 0)
+
+			// inject as a Sender
+			l, err := newLink(s, nil, tt.linkOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p, carrier := l.tracePropagator()
+			msg := NewMessage(nil)
+			err = injectInterceptor(p, carrier)(ctx, msg, func(context.Context, *Message) error { return nil })
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !testEqual(msg.ApplicationProperties, tt.wantProperties) {
+				t.Errorf("application properties don't match expected:\n %s", testDiff(msg.ApplicationProperties, tt.wantProperties))
+			}
+			if !testEqual(msg.Annotations, tt.wantAnnotations) {
+				t.Errorf("annotations don't match expected:\n %s", testDiff(msg.Annotations, tt.wantAnnotations))
+			}
+
+			// extract from the message as received by a Receiver
+			r := &Receiver{}
+			l, err = newLink(s, r, tt.linkOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.propagator, r.traceCarrier = l.tracePropagator()
+			msg.receiver = r
+
+			got, ok := TraceContextFromContext(msg.ExtractTraceContext(context.Background()))
+			if !ok || got != tc {
+				t.Errorf("ExtractTraceContext() carries %+v, want %+v", got, tc)
+			}
+		})
+	}
+}
+
+func TestExtractTraceContextWithoutPropagator(t *testing.T) {
+	msg := &Message{
+		ApplicationProperties: map[string]interface{}{"traceparent": testTraceParent},
+		receiver:              &Receiver{},
+	}
+	if _, ok := TraceContextFromContext(msg.ExtractTraceContext(context.Background())); ok {
+		t.Error("trace context extracted without a Propagator")
+	}
+}
+
+func TestValidTraceParent(t *testing.T) {
+	tests := []struct {
+		tp    string
+		valid bool
+	}{
+		{tp: testTraceParent, valid: true},
+		{tp: ""},
+		{tp: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01"},
+		{tp: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{tp: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01"},
+		{tp: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{tp: "|4bf92f3577b34da6a3ce929d0e0e4736.00f067aa0ba902b7."},
+	}
+
+	for _, tt := range tests {
+		if got := validTraceParent(tt.tp); got != tt.valid {
+			t.Errorf("validTraceParent(%q) = %t, want %t", tt.tp, got, tt.valid)
+		}
+	}
+}
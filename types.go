@@ -1,11 +1,13 @@
 package amqp
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
 	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 	"unicode/utf8"
@@ -152,6 +154,15 @@ type protoHeader struct {
 	Revision uint8
 }
 
+func (p protoHeader) String() string {
+	return fmt.Sprintf("Header{ProtoID: %s, Major: %d, Minor: %d, Revision: %d}",
+		p.ProtoID,
+		p.Major,
+		p.Minor,
+		p.Revision,
+	)
+}
+
 // frame is the decoded representation of a frame
 type frame struct {
 	type_   uint8     // AMQP/SASL
@@ -198,6 +209,23 @@ type performOpen struct {
 
 func (o *performOpen) frameBody() {}
 
+func (o *performOpen) String() string {
+	return fmt.Sprintf("Open{ContainerID: %s, Hostname: %s, MaxFrameSize: %d, ChannelMax: %d, IdleTimeout: %v, "+
+		"OutgoingLocales: %v, IncomingLocales: %v, OfferedCapabilities: %v, DesiredCapabilities: %v, "+
+		"Properties: %v}",
+		o.ContainerID,
+		o.Hostname,
+		o.MaxFrameSize,
+		o.ChannelMax,
+		o.IdleTimeout,
+		o.OutgoingLocales,
+		o.IncomingLocales,
+		o.OfferedCapabilities,
+		o.DesiredCapabilities,
+		o.Properties,
+	)
+}
+
 func (o *performOpen) marshal(wr *buffer) error {
 	return marshalComposite(wr, typeCodeOpen, []marshalField{
 		{value: &o.ContainerID, omit: false},
@@ -761,7 +789,7 @@ func (s *source) marshal(wr *buffer) error {
 		{value: s.DynamicNodeProperties, omit: len(s.DynamicNodeProperties) == 0},
 		{value: &s.DistributionMode, omit: s.DistributionMode == ""},
 		{value: s.Filter, omit: len(s.Filter) == 0},
-		{value: &s.DefaultOutcome, omit: s.DefaultOutcome == nil},
+		{value: s.DefaultOutcome, omit: s.DefaultOutcome == nil},
 		{value: &s.Outcomes, omit: len(s.Outcomes) == 0},
 		{value: &s.Capabilities, omit: len(s.Capabilities) == 0},
 	})
@@ -935,6 +963,211 @@ func (t target) String() string {
 	)
 }
 
+// Durability specifies the durability of a terminus.
+type Durability uint32
+
+// Durability Policies
+const (
+	// No terminus state is retained durably.
+	DurabilityNone Durability = 0
+
+	// Only the existence and configuration of the terminus is
+	// retained durably.
+	DurabilityConfiguration Durability = 1
+
+	// In addition to the existence and configuration of the
+	// terminus, the unsettled state for durable messages is
+	// retained durably.
+	DurabilityUnsettledState Durability = 2
+)
+
+// ExpiryPolicy specifies when the expiry timer of a terminus
+// starts counting down from the timeout value.
+type ExpiryPolicy string
+
+// Expiry Policies
+const (
+	// The expiry timer starts when terminus is detached.
+	ExpiryLinkDetach ExpiryPolicy = "link-detach"
+
+	// The expiry timer starts when the most recently
+	// associated session is ended.
+	ExpirySessionEnd ExpiryPolicy = "session-end"
+
+	// The expiry timer starts when most recently associated
+	// connection is closed.
+	ExpiryConnectionClose ExpiryPolicy = "connection-close"
+
+	// The terminus never expires.
+	ExpiryNever ExpiryPolicy = "never"
+)
+
+func (p ExpiryPolicy) validate() error {
+	switch p {
+	case ExpiryLinkDetach, ExpirySessionEnd, ExpiryConnectionClose, ExpiryNever:
+		return nil
+	default:
+		return errorErrorf("unknown expiry-policy %q", p)
+	}
+}
+
+// DistributionMode specifies how messages are distributed
+// from a source to its links.
+type DistributionMode string
+
+// Distribution Modes
+const (
+	// Once successfully transferred over the link, the message will
+	// no longer be available to other links from the same node.
+	DistributionModeMove DistributionMode = "move"
+
+	// Once successfully transferred over the link, the message is
+	// still available for other links from the same node.
+	DistributionModeCopy DistributionMode = "copy"
+)
+
+// Outcome identifies a terminal delivery state by its
+// symbolic descriptor.
+type Outcome string
+
+// Delivery Outcomes
+const (
+	OutcomeAccepted Outcome = "amqp:accepted:list"
+	OutcomeRejected Outcome = "amqp:rejected:list"
+	OutcomeReleased Outcome = "amqp:released:list"
+	OutcomeModified Outcome = "amqp:modified:list"
+)
+
+// state returns the delivery state corresponding to o.
+func (o Outcome) state() (deliveryState, error) {
+	switch o {
+	case OutcomeAccepted:
+		return &stateAccepted{}, nil
+	case OutcomeRejected:
+		return &stateRejected{}, nil
+	case OutcomeReleased:
+		return &stateReleased{}, nil
+	case OutcomeModified:
+		return &stateModified{}, nil
+	default:
+		return nil, errorErrorf("unknown outcome %q", o)
+	}
+}
+
+// Disposition is the outcome used to settle messages with
+// Receiver.Settle.
+type Disposition struct {
+	Outcome Outcome
+
+	// Error is the optional rejection error sent with OutcomeRejected.
+	Error *Error
+
+	// DeliveryFailed, UndeliverableHere and MessageAnnotations are
+	// sent with OutcomeModified. See Message.Modify.
+	DeliveryFailed     bool
+	UndeliverableHere  bool
+	MessageAnnotations Annotations
+}
+
+// state returns the delivery state corresponding to d.
+func (d Disposition) state() (deliveryState, error) {
+	switch d.Outcome {
+	case OutcomeRejected:
+		return &stateRejected{Error: d.Error}, nil
+	case OutcomeModified:
+		return &stateModified{
+			DeliveryFailed:     d.DeliveryFailed,
+			UndeliverableHere:  d.UndeliverableHere,
+			MessageAnnotations: d.MessageAnnotations,
+		}, nil
+	default:
+		return d.Outcome.state()
+	}
+}
+
+// outcomeOf returns the Outcome for a decoded delivery state,
+// or an empty Outcome if state is not an outcome.
+func outcomeOf(state interface{}) Outcome {
+	switch state.(type) {
+	case *stateAccepted:
+		return OutcomeAccepted
+	case *stateRejected:
+		return OutcomeRejected
+	case *stateReleased:
+		return OutcomeReleased
+	case *stateModified:
+		return OutcomeModified
+	default:
+		return ""
+	}
+}
+
+// Terminus describes a link source or target as
+// sent by the peer on attach.
+type Terminus struct {
+	Address      string
+	Durable      Durability
+	ExpiryPolicy ExpiryPolicy
+	Timeout      time.Duration // resolution is seconds
+	Dynamic      bool
+	Capabilities []string
+
+	// The following are only set for a source.
+	DistributionMode DistributionMode
+	Filters          []string // names of the filters applied by the peer, sorted
+	DefaultOutcome   Outcome
+	Outcomes         []Outcome
+}
+
+func (s *source) terminus() *Terminus {
+	if s == nil {
+		return nil
+	}
+	t := &Terminus{
+		Address:          s.Address,
+		Durable:          Durability(s.Durable),
+		ExpiryPolicy:     ExpiryPolicy(s.ExpiryPolicy),
+		Timeout:          time.Duration(s.Timeout) * time.Second,
+		Dynamic:          s.Dynamic,
+		Capabilities:     symbolsToStrings(s.Capabilities),
+		DistributionMode: DistributionMode(s.DistributionMode),
+		DefaultOutcome:   outcomeOf(s.DefaultOutcome),
+	}
+	for name := range s.Filter {
+		t.Filters = append(t.Filters, string(name))
+	}
+	sort.Strings(t.Filters)
+	for _, o := range s.Outcomes {
+		t.Outcomes = append(t.Outcomes, Outcome(o))
+	}
+	return t
+}
+
+func (t *target) terminus() *Terminus {
+	if t == nil {
+		return nil
+	}
+	return &Terminus{
+		Address:      t.Address,
+		Durable:      Durability(t.Durable),
+		ExpiryPolicy: ExpiryPolicy(t.ExpiryPolicy),
+		Timeout:      time.Duration(t.Timeout) * time.Second,
+		Dynamic:      t.Dynamic,
+		Capabilities: symbolsToStrings(t.Capabilities),
+	}
+}
+
+func symbolsToStrings(syms []symbol) []string {
+	if len(syms) == 0 {
+		return nil
+	}
+	strs := make([]string, len(syms))
+	for i, sym := range syms {
+		strs[i] = string(sym)
+	}
+	return strs
+}
+
 /*
 <type name="flow" class="composite" source="list" provides="frame">
     <descriptor name="amqp:flow:list" code="0x00000000:0x00000013"/>
@@ -1474,6 +1707,12 @@ func (d *performDetach) unmarshal(r *buffer) error {
 // ErrorCondition is one of the error conditions defined in the AMQP spec.
 type ErrorCondition string
 
+// Error implements the error interface, allowing an ErrorCondition to
+// be used as the target of errors.Is.
+func (ec ErrorCondition) Error() string {
+	return string(ec)
+}
+
 func (ec ErrorCondition) marshal(wr *buffer) error {
 	return (symbol)(ec).marshal(wr)
 }
@@ -1575,6 +1814,16 @@ func (e *Error) Error() string {
 	return e.String()
 }
 
+// Is reports whether target is an ErrorCondition equal to e.Condition.
+//
+// This allows checking for a condition with errors.Is, e.g.
+//
+//	errors.Is(err, amqp.ErrorNotFound)
+func (e *Error) Is(target error) bool {
+	c, ok := target.(ErrorCondition)
+	return ok && e != nil && e.Condition == c
+}
+
 /*
 <type name="end" class="composite" source="list" provides="frame">
     <descriptor name="amqp:end:list" code="0x00000000:0x00000017"/>
@@ -1591,6 +1840,10 @@ type performEnd struct {
 
 func (e *performEnd) frameBody() {}
 
+func (e *performEnd) String() string {
+	return fmt.Sprintf("End{Error: %v}", e.Error)
+}
+
 func (e *performEnd) marshal(wr *buffer) error {
 	return marshalComposite(wr, typeCodeEnd, []marshalField{
 		{value: e.Error, omit: e.Error == nil},
@@ -1632,7 +1885,7 @@ func (c *performClose) unmarshal(r *buffer) error {
 }
 
 func (c *performClose) String() string {
-	return fmt.Sprintf("*performClose{Error: %s}", c.Error)
+	return fmt.Sprintf("Close{Error: %v}", c.Error)
 }
 
 const maxDeliveryTagLength = 32
@@ -1754,29 +2007,47 @@ func (m *Message) GetData() []byte {
 // Accept notifies the server that the message has been
 // accepted and does not require redelivery.
 func (m *Message) Accept() error {
+	return m.AcceptContext(context.Background())
+}
+
+// AcceptContext is like Accept, but stops waiting for the server to
+// confirm settlement when ctx is done, in which case ctx.Err() is returned.
+func (m *Message) AcceptContext(ctx context.Context) error {
 	if !m.shouldSendDisposition() {
 		return nil
 	}
-	return m.receiver.messageDisposition(m.deliveryID, &stateAccepted{})
+	return m.receiver.messageDisposition(ctx, m.deliveryID, &stateAccepted{})
 }
 
 // Reject notifies the server that the message is invalid.
 //
 // Rejection error is optional.
 func (m *Message) Reject(e *Error) error {
+	return m.RejectContext(context.Background(), e)
+}
+
+// RejectContext is like Reject, but stops waiting for the server to
+// confirm settlement when ctx is done, in which case ctx.Err() is returned.
+func (m *Message) RejectContext(ctx context.Context, e *Error) error {
 	if !m.shouldSendDisposition() {
 		return nil
 	}
-	return m.receiver.messageDisposition(m.deliveryID, &stateRejected{Error: e})
+	return m.receiver.messageDisposition(ctx, m.deliveryID, &stateRejected{Error: e})
 }
 
 // Release releases the message back to the server. The message
 // may be redelivered to this or another consumer.
 func (m *Message) Release() error {
-	if m.shouldSendDisposition() {
+	return m.ReleaseContext(context.Background())
+}
+
+// ReleaseContext is like Release, but stops waiting for the server to
+// confirm settlement when ctx is done, in which case ctx.Err() is returned.
+func (m *Message) ReleaseContext(ctx context.Context) error {
+	if !m.shouldSendDisposition() {
 		return nil
 	}
-	return m.receiver.messageDisposition(m.deliveryID, &stateReleased{})
+	return m.receiver.messageDisposition(ctx, m.deliveryID, &stateReleased{})
 }
 
 // Modify notifies the server that the message was not acted upon
@@ -1792,10 +2063,16 @@ func (m *Message) Release() error {
 // with the existing message annotations, overwriting existing keys
 // if necessary.
 func (m *Message) Modify(deliveryFailed, undeliverableHere bool, messageAnnotations Annotations) error {
+	return m.ModifyContext(context.Background(), deliveryFailed, undeliverableHere, messageAnnotations)
+}
+
+// ModifyContext is like Modify, but stops waiting for the server to
+// confirm settlement when ctx is done, in which case ctx.Err() is returned.
+func (m *Message) ModifyContext(ctx context.Context, deliveryFailed, undeliverableHere bool, messageAnnotations Annotations) error {
 	if !m.shouldSendDisposition() {
 		return nil
 	}
-	return m.receiver.messageDisposition(m.deliveryID, &stateModified{
+	return m.receiver.messageDisposition(ctx, m.deliveryID, &stateModified{
 		DeliveryFailed:     deliveryFailed,
 		UndeliverableHere:  undeliverableHere,
 		MessageAnnotations: messageAnnotations,
@@ -2383,6 +2660,15 @@ type saslInit struct {
 
 func (si *saslInit) frameBody() {}
 
+// String omits InitialResponse, which may contain credentials.
+func (si *saslInit) String() string {
+	return fmt.Sprintf("SASLInit{Mechanism: %s, InitialResponse [size]: %d, Hostname: %s}",
+		si.Mechanism,
+		len(si.InitialResponse),
+		si.Hostname,
+	)
+}
+
 func (si *saslInit) marshal(wr *buffer) error {
 	return marshalComposite(wr, typeCodeSASLInit, []marshalField{
 		{value: &si.Mechanism, omit: false},
@@ -2412,6 +2698,10 @@ type saslMechanisms struct {
 
 func (sm *saslMechanisms) frameBody() {}
 
+func (sm *saslMechanisms) String() string {
+	return fmt.Sprintf("SASLMechanisms{Mechanisms: %v}", sm.Mechanisms)
+}
+
 func (sm *saslMechanisms) marshal(wr *buffer) error {
 	return marshalComposite(wr, typeCodeSASLMechanism, []marshalField{
 		{value: &sm.Mechanisms, omit: false},
@@ -2439,6 +2729,13 @@ type saslOutcome struct {
 
 func (so *saslOutcome) frameBody() {}
 
+func (so *saslOutcome) String() string {
+	return fmt.Sprintf("SASLOutcome{Code: %s, AdditionalData [size]: %d}",
+		so.Code,
+		len(so.AdditionalData),
+	)
+}
+
 func (so *saslOutcome) marshal(wr *buffer) error {
 	return marshalComposite(wr, typeCodeSASLOutcome, []marshalField{
 		{value: &so.Code, omit: false},
@@ -3936,6 +4233,15 @@ func (ms multiSymbol) marshal(wr *buffer) error {
 	return marshal(wr, []symbol(ms))
 }
 
+func (ms multiSymbol) contains(sym symbol) bool {
+	for _, s := range ms {
+		if s == sym {
+			return true
+		}
+	}
+	return false
+}
+
 func (ms *multiSymbol) unmarshal(r *buffer) error {
 	type_, err := r.peekType()
 	if err != nil {